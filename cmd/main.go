@@ -1,27 +1,179 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/byuoitav/sony-control-microservice/device"
+	"github.com/byuoitav/sony-control-microservice/device/allowlist"
+	"github.com/byuoitav/sony-control-microservice/device/audit"
+	"github.com/byuoitav/sony-control-microservice/device/auth"
+	"github.com/byuoitav/sony-control-microservice/device/helpers"
+	"github.com/byuoitav/sony-control-microservice/device/inventory"
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
 
 	"github.com/spf13/pflag"
 )
 
 func main() {
-	var port, logLevel string
+	var port, logLevel, pskFile, inventoryFile, authFile, auditFile string
+	var queueDepth, auditMaxSize, auditMaxFiles, verifyRetries int
+	var legacyRoutes, allowAny bool
+	var queueTimeout, cacheTTL, readTimeout, writeTimeout, idleTimeout, shutdownTimeout, powerTimeout, verifyBackoff time.Duration
+	var cacheTTLs map[string]string
+	var allowCIDRs, denyCIDRs, allowHosts, denyHosts []string
 	pflag.StringVarP(&port, "port", "p", "8007", "port for microservice to av-api communication")
 	pflag.StringVarP(&logLevel, "log", "l", "Info", "Initial log level")
+	pflag.StringVar(&pskFile, "psk-file", "", "JSON file or directory of per-device pre-shared keys (defaults to SONY_TV_PSK for every device)")
+	pflag.StringVar(&inventoryFile, "inventory", "", "JSON file of named devices, so routes can take a device id in place of an address")
+	pflag.StringVar(&authFile, "auth-file", "", "JSON file of api keys, hmac keys and/or a jwks to authenticate requests with (required unless BYPASS_AUTH=true)")
+	pflag.StringVar(&auditFile, "audit-file", "", "JSON-lines file to record every action taken on a device in")
+	pflag.IntVar(&auditMaxSize, "audit-max-size", audit.DefaultMaxSize>>20, "size in MB the audit file is rotated at")
+	pflag.IntVar(&auditMaxFiles, "audit-max-files", audit.DefaultMaxFiles, "how many rotated audit files to keep")
+	pflag.IntVar(&queueDepth, "queue-depth", device.DefaultQueueDepth, "how many actions can wait for each device before new ones are rejected")
+	pflag.DurationVar(&queueTimeout, "queue-timeout", device.DefaultQueueTimeout, "how long an action can wait for the ones queued before it")
+	pflag.DurationVar(&cacheTTL, "cache-ttl", device.DefaultCacheTTL, "how long status reads are cached for")
+	pflag.StringToStringVar(&cacheTTLs, "cache-ttls", nil, "per-field cache ttls, e.g. power=5s,audio=1s (fields: power, input, audio, blanked)")
+	pflag.DurationVar(&readTimeout, "read-timeout", device.DefaultReadTimeout, "how long the server waits to read a request")
+	pflag.DurationVar(&writeTimeout, "write-timeout", device.DefaultWriteTimeout, "how long a request can take to respond, including waiting for a tv to change power")
+	pflag.DurationVar(&idleTimeout, "idle-timeout", device.DefaultIdleTimeout, "how long idle keep-alive connections are kept open")
+	pflag.DurationVar(&shutdownTimeout, "shutdown-timeout", device.DefaultShutdownTimeout, "how long in-flight requests get to finish on SIGTERM before they're cancelled")
+	pflag.DurationVar(&powerTimeout, "power-timeout", device.DefaultPowerTimeout, "how long a tv gets to turn on or off before the request fails")
+	pflag.IntVar(&verifyRetries, "verify-retries", device.DefaultVerifyRetries, "how many times to retry a change that doesn't show up when the tv is read back")
+	pflag.DurationVar(&verifyBackoff, "verify-backoff", device.DefaultVerifyBackoff, "how long to wait before reading the tv back again, doubled for each retry")
+	pflag.BoolVar(&legacyRoutes, "legacy-routes", true, "also serve the original GET-only endpoints used by the av-api")
+	pflag.StringSliceVar(&allowCIDRs, "allow-cidrs", nil, "networks devices may be in, e.g. 10.5.0.0/16 (defaults to private networks)")
+	pflag.StringSliceVar(&denyCIDRs, "deny-cidrs", nil, "networks devices may never be in, e.g. 169.254.0.0/16")
+	pflag.StringSliceVar(&allowHosts, "allow-hosts", nil, "hostnames devices may have, e.g. *.byu.edu (defaults to any that resolve to private networks)")
+	pflag.StringSliceVar(&denyHosts, "deny-hosts", nil, "hostnames devices may never have")
+	pflag.BoolVar(&allowAny, "allow-any", false, "allow devices at public addresses when there's no allowlist")
 	pflag.Parse()
 
 	port = ":" + port
 
 	manager := device.DeviceManager{
-		Log: buildLogger(logLevel),
+		Log:          buildLogger(logLevel),
+		QueueDepth:   queueDepth,
+		QueueTimeout: queueTimeout,
+		CacheTTL:     cacheTTL,
+		CacheTTLs:    make(map[string]time.Duration),
+
+		ReadTimeout:     readTimeout,
+		WriteTimeout:    writeTimeout,
+		IdleTimeout:     idleTimeout,
+		ShutdownTimeout: shutdownTimeout,
+		PowerTimeout:    powerTimeout,
+		VerifyRetries:   verifyRetries,
+		VerifyBackoff:   verifyBackoff,
+
+		LegacyRoutes: legacyRoutes,
+	}
+
+	for field, val := range cacheTTLs {
+		ttl, err := time.ParseDuration(val)
+		if err != nil {
+			manager.Log.Fatal("invalid cache ttl", zap.String("field", field), zap.Error(err))
+		}
+
+		manager.CacheTTLs[field] = ttl
+	}
+
+	if pskFile != "" {
+		creds, err := helpers.NewCredentialStore(pskFile)
+		if err != nil {
+			manager.Log.Fatal("unable to load credentials", zap.Error(err))
+		}
+
+		helpers.Credentials = creds
+		go creds.Watch(30*time.Second, manager.Log)
+	}
+
+	if inventoryFile != "" {
+		inv, err := inventory.Load(inventoryFile)
+		if err != nil {
+			manager.Log.Fatal("unable to load inventory", zap.String("path", inventoryFile), zap.Error(err))
+		}
+
+		helpers.Inventory = inv
+	}
+
+	if auditFile != "" {
+		log, err := audit.Open(auditFile, int64(auditMaxSize)<<20, auditMaxFiles)
+		if err != nil {
+			manager.Log.Fatal("unable to open audit log", zap.String("path", auditFile), zap.Error(err))
+		}
+
+		manager.Audit = log
+	}
+
+	// only connect to the devices we're supposed to, so that we can't be used to leak our keys
+	allowed, err := allowlist.New(allowCIDRs, denyCIDRs, allowHosts, denyHosts)
+	if err != nil {
+		manager.Log.Fatal("invalid allowlist", zap.Error(err))
 	}
 
+	allowed.Log = manager.Log
+	allowed.AllowAny = allowAny
+	helpers.Allowlist = allowed
+	helpers.Client.Dial = allowed.DialContext
+
+	if len(allowCIDRs) == 0 && len(allowHosts) == 0 {
+		if allowAny {
+			manager.Log.Warn("no allowlist is set and -allow-any is on, so our keys will be sent to any address a request names")
+		} else {
+			manager.Log.Warn("no allowlist is set, so only devices at private addresses can be controlled. Set -allow-cidrs and/or -allow-hosts")
+		}
+	}
+
+	var authConfig *auth.Config
+	if os.Getenv("BYPASS_AUTH") == "true" {
+		manager.Log.Warn("BYPASS_AUTH is set, requests won't be authenticated")
+	} else {
+		if authFile == "" {
+			manager.Log.Fatal("no authentication configured: pass --auth-file, or set BYPASS_AUTH=true for development")
+		}
+
+		authConfig, err = auth.Load(authFile)
+		if err != nil {
+			manager.Log.Fatal("unable to load auth config", zap.String("path", authFile), zap.Error(err))
+		}
+	}
+
+	// reload everything we read from disk on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := helpers.Credentials.Reload(); err != nil {
+				manager.Log.Error("unable to reload credentials", zap.Error(err))
+			} else {
+				manager.Log.Info("reloaded credentials")
+			}
+
+			if err := helpers.Inventory.Reload(); err != nil {
+				manager.Log.Error("unable to reload inventory", zap.Error(err))
+			} else {
+				manager.Log.Info("reloaded inventory", zap.Int("devices", len(helpers.Inventory.Devices())))
+			}
+
+			if err := authConfig.Reload(); err != nil {
+				manager.Log.Error("unable to reload auth config", zap.Error(err))
+			} else if authConfig != nil {
+				manager.Log.Info("reloaded auth config")
+			}
+		}
+	}()
+
 	router := gin.Default()
+	router.Use(device.RequestID)
+	if authConfig != nil {
+		router.Use(manager.Authenticate(authConfig))
+	}
 	router.GET("/ping", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			"message": "pong",
@@ -34,5 +186,19 @@ func main() {
 		})
 	})
 
-	manager.RunHTTPServer(router, port)
+	// drain in-flight requests on SIGTERM (e.g. during a deploy) or ctrl-c
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	err = manager.RunHTTPServer(ctx, router, port)
+
+	if err := manager.Audit.Close(); err != nil {
+		manager.Log.Error("unable to close audit log", zap.Error(err))
+	}
+
+	manager.Log.Sync()
+
+	if err != nil {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,63 @@
+// Command simulator runs one or more fake Sony Bravia TVs for local testing
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/byuoitav/sony-control-microservice/simulator"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+)
+
+func main() {
+	var port, count, staleMuteReads int
+	var psk string
+	var latency, powerOnDelay, powerOffDelay time.Duration
+	var dropRate, errorRate float64
+	var displayOffErrors bool
+	pflag.IntVarP(&port, "port", "p", 8080, "port for the first simulated tv")
+	pflag.IntVarP(&count, "count", "c", 1, "number of simulated tvs to run, on consecutive ports")
+	pflag.StringVar(&psk, "psk", os.Getenv("SONY_TV_PSK"), "pre-shared key the simulated tvs require")
+	pflag.DurationVar(&latency, "latency", 0, "latency added to every request")
+	pflag.Float64Var(&dropRate, "drop-rate", 0, "fraction of requests whose connection is dropped")
+	pflag.Float64Var(&errorRate, "error-rate", 0, "fraction of requests answered with an http 500")
+	pflag.BoolVar(&displayOffErrors, "display-off-errors", false, "return error 40005 from audio and avContent methods while the display is off")
+	pflag.DurationVar(&powerOnDelay, "power-on-delay", 0, "how long getPowerStatus reports standby after the tv is turned on")
+	pflag.DurationVar(&powerOffDelay, "power-off-delay", 0, "how long getPowerStatus reports active after the tv is turned off")
+	pflag.IntVar(&staleMuteReads, "stale-mute-reads", 0, "how many volume reads after a mute change still report the old mute status")
+	pflag.Parse()
+
+	log, err := zap.NewDevelopment()
+	if err != nil {
+		panic(fmt.Sprintf("unable to build logger: %s", err))
+	}
+
+	errs := make(chan error, count)
+	for i := 0; i < count; i++ {
+		tv := simulator.New(psk)
+		tv.Serial = strconv.Itoa(4000001 + i)
+		tv.MAC = fmt.Sprintf("fc:f1:52:00:00:%02x", i+1)
+		tv.SetFaults(simulator.Faults{
+			Latency:          simulator.Duration(latency),
+			DropRate:         dropRate,
+			ErrorRate:        errorRate,
+			DisplayOffErrors: displayOffErrors,
+			PowerOnDelay:     simulator.Duration(powerOnDelay),
+			PowerOffDelay:    simulator.Duration(powerOffDelay),
+			StaleMuteReads:   staleMuteReads,
+		})
+
+		addr := fmt.Sprintf(":%d", port+i)
+		log.Info("running simulated tv", zap.String("address", "localhost"+addr), zap.String("serial", tv.Serial))
+
+		go func() {
+			errs <- http.ListenAndServe(addr, tv)
+		}()
+	}
+
+	log.Fatal("simulated tv stopped", zap.Error(<-errs))
+}
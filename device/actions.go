@@ -0,0 +1,118 @@
+package device
+
+import (
+	"context"
+
+	"github.com/byuoitav/sony-control-microservice/device/helpers"
+)
+
+// The actions below are shared by the legacy and v2 routes. Each one is checked against the device's
+// policy, runs through the device's queue and clears whatever it may have changed from the status cache.
+// The ones that return a bool report whether the change was verified by reading the device back
+
+// changePower turns the device on or to standby. If progress isn't nil, it's called with each power
+// status the device reports while it's changing
+func (d *DeviceManager) changePower(ctx context.Context, address string, on bool, progress func(power string)) error {
+	power := "standby"
+	if on {
+		power = "on"
+	}
+
+	if err := checkPolicy(address, DesiredState{Power: &power}); err != nil {
+		return err
+	}
+
+	err := d.enqueue(ctx, address, func() error {
+		return d.setPower(ctx, address, on, progress)
+	})
+	d.cache.invalidate(address, allFields...)
+
+	return err
+}
+
+// setPower changes the device's power, giving up on the transition after PowerTimeout
+func (d *DeviceManager) setPower(ctx context.Context, address string, on bool, progress func(power string)) error {
+	ctx, cancel := context.WithTimeout(ctx, withDefault(d.PowerTimeout, DefaultPowerTimeout))
+	defer cancel()
+
+	return helpers.SetPower(ctx, address, on, d, progress)
+}
+
+func (d *DeviceManager) changeInput(ctx context.Context, address, port string) (bool, error) {
+	if err := checkPolicy(address, DesiredState{Input: &port}); err != nil {
+		return false, err
+	}
+
+	var verified bool
+	err := d.enqueue(ctx, address, func() error {
+		var err error
+		verified, err = d.setInput(ctx, address, port)
+		return err
+	})
+	d.cache.invalidate(address, FieldInput)
+
+	return verified, err
+}
+
+func (d *DeviceManager) changeVolume(ctx context.Context, address string, volume int) (bool, error) {
+	if err := checkPolicy(address, DesiredState{Volume: &volume}); err != nil {
+		return false, err
+	}
+
+	var verified bool
+	err := d.enqueue(ctx, address, func() error {
+		var err error
+		verified, err = d.setVolume(ctx, address, volume)
+		return err
+	})
+	d.cache.invalidate(address, FieldAudio)
+
+	return verified, err
+}
+
+func (d *DeviceManager) changeMute(ctx context.Context, address string, muted bool) (bool, error) {
+	if err := checkPolicy(address, DesiredState{Muted: &muted}); err != nil {
+		return false, err
+	}
+
+	var verified bool
+	err := d.enqueue(ctx, address, func() error {
+		var err error
+		verified, err = d.setMute(ctx, address, muted)
+		return err
+	})
+	d.cache.invalidate(address, FieldAudio)
+
+	return verified, err
+}
+
+func (d *DeviceManager) changeBlanked(ctx context.Context, address string, blanked bool) (bool, error) {
+	if err := checkPolicy(address, DesiredState{Blanked: &blanked}); err != nil {
+		return false, err
+	}
+
+	var verified bool
+	err := d.enqueue(ctx, address, func() error {
+		var err error
+		verified, err = d.setBlanked(ctx, address, blanked)
+		return err
+	})
+	d.cache.invalidate(address, FieldBlanked)
+
+	return verified, err
+}
+
+// pressRemoteKey sends a key from the device's remote. Keys that can put the device in standby are
+// checked against its policy like a standby request
+func (d *DeviceManager) pressRemoteKey(ctx context.Context, address, key string) error {
+	if err := checkPolicy(address, remoteKeyState(key)); err != nil {
+		return err
+	}
+
+	err := d.enqueue(ctx, address, func() error {
+		return helpers.SendRemoteKey(ctx, address, key, d)
+	})
+	d.cache.invalidate(address, allFields...)
+
+	return err
+}
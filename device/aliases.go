@@ -0,0 +1,92 @@
+package device
+
+import (
+	"context"
+
+	"github.com/byuoitav/sony-control-microservice/device/helpers"
+	"github.com/gin-gonic/gin"
+)
+
+// Input is an input on the TV, along with its alias from the inventory if it has one
+type Input struct {
+	Input string `json:"input,omitempty"`
+	Alias string `json:"alias,omitempty"`
+}
+
+// ActiveSignal is whether an input on the TV has signal
+type ActiveSignal struct {
+	Active bool   `json:"active"`
+	Input  string `json:"input"`
+	Alias  string `json:"alias,omitempty"`
+}
+
+// inputPort resolves an input alias for the device at address, e.g. "laptop", to its port.
+// Anything that isn't an alias is returned as is
+func inputPort(address, input string) string {
+	if dev, ok := helpers.Inventory.Lookup(address); ok {
+		return dev.Port(input)
+	}
+
+	return input
+}
+
+// resolveAliases replaces an input alias in s with its port
+func (s *DesiredState) resolveAliases(address string) {
+	if s.Input != nil {
+		port := inputPort(address, *s.Input)
+		s.Input = &port
+	}
+}
+
+// newInput adds the inventory alias of port, if it has one
+func newInput(address, port string) Input {
+	input := Input{
+		Input: port,
+	}
+
+	if dev, ok := helpers.Inventory.Lookup(address); ok && port != "" {
+		input.Alias = dev.Alias(port)
+	}
+
+	return input
+}
+
+// readAliasedInput is readInput with the input's alias
+func (d *DeviceManager) readAliasedInput(context *gin.Context, address string) (Input, error) {
+	input, err := d.readInput(context.Request.Context(), address, isFresh(context))
+	if err != nil {
+		return Input{}, err
+	}
+
+	return newInput(address, input.Input), nil
+}
+
+// getInputList is helpers.GetInputList with each input's alias
+func (d *DeviceManager) getInputList(ctx context.Context, address string) ([]helpers.InputInfo, error) {
+	inputs, err := helpers.GetInputList(ctx, address, d)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range inputs {
+		inputs[i].Alias = newInput(address, inputs[i].Port).Alias
+	}
+
+	return inputs, nil
+}
+
+// getActiveSignal is helpers.GetActiveSignal for an input port or alias
+func (d *DeviceManager) getActiveSignal(ctx context.Context, address, input string) (ActiveSignal, error) {
+	port := inputPort(address, input)
+
+	signal, err := helpers.GetActiveSignal(ctx, address, port, d)
+	if err != nil {
+		return ActiveSignal{}, err
+	}
+
+	return ActiveSignal{
+		Active: signal.Active,
+		Input:  port,
+		Alias:  newInput(address, port).Alias,
+	}, nil
+}
@@ -0,0 +1,229 @@
+// Package allowlist decides which hosts we're allowed to connect to, so that the service can't be
+// used as an open proxy (and hand our pre-shared keys to whatever host is in a request's :address)
+package allowlist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// ErrNotAllowed is wrapped by errors for connections the list doesn't allow
+var ErrNotAllowed = errors.New("address not allowed")
+
+// List is a set of allowed and denied hosts and networks. A connection is checked in this order:
+//
+//   - denied if the hostname is in DenyHosts
+//   - denied if any ip the hostname resolves to is in DenyCIDRs
+//   - if neither AllowHosts nor AllowCIDRs is set, allowed if AllowAny is set, or if every ip the
+//     hostname resolves to is private (RFC 1918, RFC 4193 or loopback)
+//   - allowed if the hostname is in AllowHosts
+//   - allowed if every ip the hostname resolves to is in AllowCIDRs
+//   - denied otherwise
+//
+// Hosts are matched case insensitively, and may start with "*." to match every subdomain, e.g. *.byu.edu.
+// The zero value allows only private addresses
+type List struct {
+	AllowCIDRs []*net.IPNet
+	DenyCIDRs  []*net.IPNet
+	AllowHosts []string
+	DenyHosts  []string
+
+	// AllowAny allows public addresses when there's no allowlist
+	AllowAny bool
+
+	// Log is where violations are logged. Nothing is logged if it's nil
+	Log *zap.Logger
+
+	// Resolver is used to look up hostnames. net.DefaultResolver is used if it's nil
+	Resolver *net.Resolver
+}
+
+// New builds a list from cidrs and host patterns
+func New(allowCIDRs, denyCIDRs, allowHosts, denyHosts []string) (*List, error) {
+	l := &List{
+		AllowHosts: normalizeHosts(allowHosts),
+		DenyHosts:  normalizeHosts(denyHosts),
+	}
+
+	var err error
+	if l.AllowCIDRs, err = parseCIDRs(allowCIDRs); err != nil {
+		return nil, err
+	}
+
+	if l.DenyCIDRs, err = parseCIDRs(denyCIDRs); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// parseCIDRs parses cidrs, treating a bare ip as a network with just that ip in it
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip %q", cidr)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr %q: %w", cidr, err)
+		}
+
+		nets = append(nets, network)
+	}
+
+	return nets, nil
+}
+
+func normalizeHosts(hosts []string) []string {
+	var normalized []string
+	for _, host := range hosts {
+		normalized = append(normalized, strings.ToLower(strings.TrimSuffix(host, ".")))
+	}
+
+	return normalized
+}
+
+// Check returns an error if the list doesn't allow connecting to host, which may be a hostname or an ip.
+// It returns the ips host resolved to that may be connected to
+func (l *List) Check(ctx context.Context, host string) ([]net.IP, error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		resolver := net.DefaultResolver
+		if l != nil && l.Resolver != nil {
+			resolver = l.Resolver
+		}
+
+		addrs, err := resolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+
+	if l == nil {
+		return ips, nil
+	}
+
+	if matchHost(l.DenyHosts, host) {
+		return nil, l.violation(host, "host is denied")
+	}
+
+	for _, ip := range ips {
+		if matchIP(l.DenyCIDRs, ip) {
+			return nil, l.violation(host, fmt.Sprintf("%s is in a denied network", ip))
+		}
+	}
+
+	if len(l.AllowHosts) == 0 && len(l.AllowCIDRs) == 0 {
+		if l.AllowAny {
+			return ips, nil
+		}
+
+		for _, ip := range ips {
+			if !ip.IsPrivate() && !ip.IsLoopback() {
+				return nil, l.violation(host, fmt.Sprintf("%s is a public address, and no allowlist is set", ip))
+			}
+		}
+
+		return ips, nil
+	}
+
+	if matchHost(l.AllowHosts, host) {
+		return ips, nil
+	}
+
+	for _, ip := range ips {
+		if !matchIP(l.AllowCIDRs, ip) {
+			return nil, l.violation(host, fmt.Sprintf("%s isn't in an allowed network", ip))
+		}
+	}
+
+	return ips, nil
+}
+
+func (l *List) violation(host, reason string) error {
+	if l.Log != nil {
+		l.Log.Warn("blocked connection to address", zap.String("host", host), zap.String("reason", reason))
+	}
+
+	return fmt.Errorf("%w: %s (%s)", ErrNotAllowed, host, reason)
+}
+
+func matchHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if pattern == host {
+			return true
+		}
+
+		if strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, network := range nets {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DialContext connects to address if the list allows it. The ips that were checked are the ones
+// that are dialed, so a hostname can't resolve to something else between checking and connecting
+func (l *List) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := l.Check(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var dialer net.Dialer
+	for _, ip := range ips {
+		var conn net.Conn
+		conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+
+	if err == nil {
+		err = fmt.Errorf("no addresses found for %s", host)
+	}
+
+	return nil, err
+}
@@ -0,0 +1,54 @@
+package allowlist
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	mustNew := func(allowCIDRs, denyCIDRs, allowHosts, denyHosts []string) *List {
+		l, err := New(allowCIDRs, denyCIDRs, allowHosts, denyHosts)
+		if err != nil {
+			t.Fatalf("unable to build list: %s", err)
+		}
+
+		return l
+	}
+
+	allowAny := mustNew(nil, []string{"10.9.0.0/16"}, nil, nil)
+	allowAny.AllowAny = true
+
+	tests := []struct {
+		name    string
+		list    *List
+		host    string
+		allowed bool
+	}{
+		{"default allows rfc 1918", &List{}, "10.5.1.20", true},
+		{"default allows 192.168", &List{}, "192.168.1.20", true},
+		{"default allows loopback", &List{}, "127.0.0.1", true},
+		{"default allows ula", &List{}, "fd00::20", true},
+		{"default denies public", &List{}, "8.8.8.8", false},
+		{"default denies link local", &List{}, "169.254.169.254", false},
+		{"allow any", allowAny, "8.8.8.8", true},
+		{"deny wins over allow any", allowAny, "10.9.1.1", false},
+
+		{"in allowed cidr", mustNew([]string{"10.5.0.0/16"}, nil, nil, nil), "10.5.1.20", true},
+		{"outside allowed cidr", mustNew([]string{"10.5.0.0/16"}, nil, nil, nil), "10.6.1.20", false},
+		{"bare ip", mustNew([]string{"8.8.8.8"}, nil, nil, nil), "8.8.8.8", true},
+		{"denied cidr", mustNew([]string{"10.0.0.0/8"}, []string{"10.5.0.0/16"}, nil, nil), "10.5.1.20", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.list.Check(context.Background(), tt.host)
+			switch {
+			case tt.allowed && err != nil:
+				t.Fatalf("%s was denied: %s", tt.host, err)
+			case !tt.allowed && !errors.Is(err, ErrNotAllowed):
+				t.Fatalf("got %v for %s, want %s", err, tt.host, ErrNotAllowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package device
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/byuoitav/sony-control-microservice/device/audit"
+	"github.com/byuoitav/sony-control-microservice/device/auth"
+	"github.com/byuoitav/sony-control-microservice/device/helpers"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxAuditBody is the most of a request's body that's kept in its audit record
+	maxAuditBody = 16 << 10
+
+	// maxRequestBody is the largest body an action will read. Larger ones are rejected with a 413.
+	// It's the same limit HMAC-signed requests are held to before they're authenticated
+	maxRequestBody = auth.MaxBodySize
+
+	// defaultAuditLimit is how many records GET /audit returns if it isn't given a limit
+	defaultAuditLimit = 1000
+)
+
+// recordAction is middleware that writes an audit record for the request: who made it, what they
+// asked for, what the device's state was before (if it's cached), and how it went. It runs before
+// the request is authorized, so that rejected requests are recorded too
+func (d *DeviceManager) recordAction(context *gin.Context) {
+	start := time.Now()
+	address := context.Param("address")
+
+	record := audit.Record{
+		Time:      start,
+		RequestID: requestID(context),
+		SourceIP:  context.ClientIP(),
+		Address:   address,
+		Action:    context.Request.Method + " " + context.FullPath(),
+		Prior:     d.cache.snapshot(address),
+	}
+
+	if dev, ok := helpers.Inventory.Lookup(address); ok {
+		record.Device = dev.ID
+	}
+
+	for _, param := range context.Params {
+		if param.Key == "address" {
+			continue
+		}
+
+		if record.Params == nil {
+			record.Params = make(map[string]string)
+		}
+
+		record.Params[param.Key] = param.Value
+	}
+
+	if context.Request.Body != nil {
+		body, err := io.ReadAll(http.MaxBytesReader(context.Writer, context.Request.Body, maxRequestBody))
+		if err != nil {
+			// aborts the request, so the handler is skipped but the rejection is still recorded
+			d.respondError(context, "Unable to read request body", err)
+		} else {
+			context.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+			if len(body) <= maxAuditBody && json.Valid(body) {
+				record.Body = body
+			}
+		}
+	}
+
+	context.Next()
+
+	if id, ok := identity(context); ok {
+		record.Caller = id.Name
+		record.Auth = id.Method
+	}
+
+	record.Status = context.Writer.Status()
+	if resp, ok := context.Get(errorKey); ok {
+		record.ErrorCode = resp.(ErrorResponse).Code
+		record.Error = resp.(ErrorResponse).Message
+	}
+
+	record.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+
+	if err := d.Audit.Write(record); err != nil {
+		d.Log.Error("unable to write audit record", zap.Any("record", record), zap.Error(err))
+	}
+}
+
+// GetAudit returns the audit records for a device (?device=, an inventory id or address) since a
+// time (?since=, either RFC 3339 or a duration before now, like 24h)
+func (d *DeviceManager) GetAudit(context *gin.Context) {
+	query := audit.Query{
+		Limit: defaultAuditLimit,
+	}
+
+	if device := context.Query("device"); device != "" {
+		query.Devices = []string{device}
+		if dev, ok := helpers.Inventory.Lookup(device); ok {
+			query.Devices = append(query.Devices, dev.ID, dev.Address)
+		}
+	}
+
+	if since := context.Query("since"); since != "" {
+		if t, err := time.Parse(time.RFC3339, since); err == nil {
+			query.Since = t
+		} else if ago, err := time.ParseDuration(since); err == nil {
+			query.Since = time.Now().Add(-ago)
+		} else {
+			d.respondError(context, "Invalid audit query", invalidRequest("since should be a time (RFC 3339) or a duration, not %q", since))
+			return
+		}
+	}
+
+	if limit := context.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			d.respondError(context, "Invalid audit query", invalidRequest("limit should be a positive number, not %q", limit))
+			return
+		}
+
+		query.Limit = n
+	}
+
+	records, err := d.Audit.Query(query)
+	if err != nil {
+		d.respondError(context, "Failed to query audit log", err)
+		return
+	}
+
+	context.JSON(http.StatusOK, Response{Data: records})
+}
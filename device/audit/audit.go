@@ -0,0 +1,318 @@
+// Package audit keeps an append-only record of every change made to our TVs, so that we can tell
+// who turned a TV off mid-lecture
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Record is a single action taken on a device
+type Record struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"requestId,omitempty"`
+
+	// Caller is the name of the credential the request was made with, and Auth is how it was checked
+	Caller   string `json:"caller,omitempty"`
+	Auth     string `json:"auth,omitempty"`
+	SourceIP string `json:"sourceIP"`
+
+	// Device is the device's inventory id, if it has one
+	Device  string `json:"device,omitempty"`
+	Address string `json:"address"`
+
+	// Action is the method and route of the request, e.g. "GET /:address/power/on", and Params and
+	// Body are what was asked for
+	Action string            `json:"action"`
+	Params map[string]string `json:"params,omitempty"`
+	Body   json.RawMessage   `json:"body,omitempty"`
+
+	// Prior is the state of the device before the action, if it was known
+	Prior map[string]interface{} `json:"prior,omitempty"`
+
+	Status    int     `json:"status"`
+	ErrorCode string  `json:"errorCode,omitempty"`
+	Error     string  `json:"error,omitempty"`
+	LatencyMS float64 `json:"latencyMs"`
+}
+
+// Defaults for Open
+const (
+	DefaultMaxSize  = 100 << 20
+	DefaultMaxFiles = 5
+)
+
+// Log is an append-only file of Records, one JSON object per line. When the file grows past
+// MaxSize, it's rotated to <path>.1 (and <path>.1 to <path>.2, ...), keeping MaxFiles old files.
+// A nil Log drops every record
+type Log struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// Open opens (or creates) the log at path. maxSize and maxFiles fall back to their defaults if they're 0
+func Open(path string, maxSize int64, maxFiles int) (*Log, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+
+	if maxFiles <= 0 {
+		maxFiles = DefaultMaxFiles
+	}
+
+	l := &Log{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *Log) open() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return fmt.Errorf("unable to open audit log: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("unable to open audit log: %w", err)
+	}
+
+	l.file = file
+	l.size = info.Size()
+
+	return nil
+}
+
+// Write appends r to the log
+func (l *Log) Write(r Record) error {
+	if l == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("unable to encode audit record: %w", err)
+	}
+
+	b = append(b, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return fmt.Errorf("audit log is closed")
+	}
+
+	if l.size > 0 && l.size+int64(len(b)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.file.Write(b)
+	l.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("unable to write audit record: %w", err)
+	}
+
+	return nil
+}
+
+// rotate moves every file up one number, dropping the oldest, and starts a new file
+func (l *Log) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("unable to rotate audit log: %w", err)
+	}
+
+	for i := l.maxFiles - 1; i > 0; i-- {
+		os.Rename(l.rotated(i), l.rotated(i+1))
+	}
+
+	if err := os.Rename(l.path, l.rotated(1)); err != nil {
+		return fmt.Errorf("unable to rotate audit log: %w", err)
+	}
+
+	return l.open()
+}
+
+func (l *Log) rotated(i int) string {
+	return fmt.Sprintf("%s.%d", l.path, i)
+}
+
+// Close closes the log's file
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+
+	err := l.file.Close()
+	l.file = nil
+
+	return err
+}
+
+// Query is what to look for in the log
+type Query struct {
+	// Devices matches records whose device id or address is one of these, ignoring case. Empty matches every record
+	Devices []string
+
+	// Since matches records at or after it
+	Since time.Time
+
+	// Limit is the most records to return; the newest are kept
+	Limit int
+}
+
+func (q Query) matches(r Record) bool {
+	if r.Time.Before(q.Since) {
+		return false
+	}
+
+	if len(q.Devices) == 0 {
+		return true
+	}
+
+	for _, dev := range q.Devices {
+		if strings.EqualFold(dev, r.Device) || strings.EqualFold(dev, r.Address) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Query returns the records that match q, oldest first. The files are opened while holding the lock,
+// so that they can't be rotated out from under us, but read without it, so that writes aren't held up
+func (l *Log) Query(q Query) ([]Record, error) {
+	if l == nil {
+		return []Record{}, nil
+	}
+
+	files, err := l.snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+
+	matches := newRing(q.Limit)
+	for _, file := range files {
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64<<10), 1<<20)
+
+		for scanner.Scan() {
+			var r Record
+			if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				continue
+			}
+
+			if q.matches(r) {
+				matches.add(r)
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("unable to read audit log: %w", err)
+		}
+	}
+
+	return matches.records(), nil
+}
+
+// snapshot opens every file in the log, oldest first. The current file is only read up to its size
+// now, so that records written while it's being read (which may be partly written) are left out
+func (l *Log) snapshot() ([]io.ReadCloser, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var files []io.ReadCloser
+	fail := func(err error) ([]io.ReadCloser, error) {
+		for _, file := range files {
+			file.Close()
+		}
+
+		return nil, fmt.Errorf("unable to read audit log: %w", err)
+	}
+
+	for i := l.maxFiles; i > 0; i-- {
+		file, err := os.Open(l.rotated(i))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return fail(err)
+		}
+
+		files = append(files, file)
+	}
+
+	file, err := os.Open(l.path)
+	if err != nil && !os.IsNotExist(err) {
+		return fail(err)
+	} else if err == nil {
+		files = append(files, limitedFile{io.LimitReader(file, l.size), file})
+	}
+
+	return files, nil
+}
+
+// limitedFile is a file that's only read up to a limit
+type limitedFile struct {
+	io.Reader
+	io.Closer
+}
+
+// ring keeps the last limit records it's given, or every record if limit isn't positive
+type ring struct {
+	limit int
+	buf   []Record
+	next  int
+}
+
+func newRing(limit int) *ring {
+	return &ring{limit: limit}
+}
+
+func (r *ring) add(record Record) {
+	if r.limit <= 0 || len(r.buf) < r.limit {
+		r.buf = append(r.buf, record)
+		return
+	}
+
+	r.buf[r.next] = record
+	r.next = (r.next + 1) % r.limit
+}
+
+// records returns the records, oldest first
+func (r *ring) records() []Record {
+	return append(append([]Record{}, r.buf[r.next:]...), r.buf[:r.next]...)
+}
@@ -0,0 +1,98 @@
+package audit
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	// small enough that the records are spread over a few rotated files
+	log, err := Open(path, 1<<10, 3)
+	if err != nil {
+		t.Fatalf("unable to open log: %s", err)
+	}
+	defer log.Close()
+
+	start := time.Now()
+	for i := 0; i < 30; i++ {
+		address := "10.0.0.1"
+		if i%2 == 1 {
+			address = "10.0.0.2"
+		}
+
+		err := log.Write(Record{
+			Time:    start.Add(time.Duration(i) * time.Second),
+			Address: address,
+			Action:  "GET /:address/power/on",
+			Status:  i,
+		})
+		if err != nil {
+			t.Fatalf("unable to write record %d: %s", i, err)
+		}
+	}
+
+	all, err := log.Query(Query{})
+	if err != nil {
+		t.Fatalf("unable to query log: %s", err)
+	}
+
+	if len(all) == 0 || len(all) == 30 {
+		t.Fatalf("got %d records, want some (but not all) of them to have been rotated out", len(all))
+	}
+
+	for i := 1; i < len(all); i++ {
+		if all[i].Status != all[i-1].Status+1 {
+			t.Fatalf("records aren't in order: %d came after %d", all[i].Status, all[i-1].Status)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []int
+	}{
+		{"limit keeps the newest", Query{Limit: 3}, []int{27, 28, 29}},
+		{"device", Query{Devices: []string{"10.0.0.2"}, Limit: 2}, []int{27, 29}},
+		{"since", Query{Since: start.Add(28 * time.Second)}, []int{28, 29}},
+		{"no match", Query{Devices: []string{"10.0.0.3"}}, []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := log.Query(tt.query)
+			if err != nil {
+				t.Fatalf("unable to query log: %s", err)
+			}
+
+			got := []int{}
+			for _, r := range records {
+				got = append(got, r.Status)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("got records %v, want %v", got, tt.want)
+			}
+
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got records %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRing(t *testing.T) {
+	r := newRing(3)
+	for i := 0; i < 7; i++ {
+		r.add(Record{Status: i})
+	}
+
+	got := r.records()
+	if len(got) != 3 || got[0].Status != 4 || got[1].Status != 5 || got[2].Status != 6 {
+		t.Fatalf("got %+v, want the last 3 records in order", got)
+	}
+}
@@ -0,0 +1,78 @@
+package device
+
+import (
+	"fmt"
+
+	"github.com/byuoitav/sony-control-microservice/device/auth"
+	"github.com/byuoitav/sony-control-microservice/device/helpers"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const identityKey = "identity"
+
+// Authenticate is middleware that rejects requests without valid credentials, unless their path is
+// exempt. The caller's identity is kept on the request for the handlers that need it
+func (d *DeviceManager) Authenticate(config *auth.Config) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		if config.Exempt(context.Request.URL.Path) {
+			context.Next()
+			return
+		}
+
+		id, err := config.Authenticate(context.Request)
+		if err != nil {
+			code, resp := errorResponse(context, err)
+
+			d.Log.Warn("Rejected request", zap.String("path", context.Request.URL.Path), zap.String("clientIP", context.ClientIP()), zap.String("requestID", resp.RequestID), zap.Error(err))
+			writeError(context, code, resp, nil)
+			return
+		}
+
+		context.Set(identityKey, id)
+		context.Next()
+	}
+}
+
+// identity returns who made the request, if they were authenticated
+func identity(context *gin.Context) (auth.Identity, bool) {
+	id, ok := context.Get(identityKey)
+	if !ok {
+		return auth.Identity{}, false
+	}
+
+	return id.(auth.Identity), true
+}
+
+// authorize is middleware that rejects requests from callers whose role is below role, or whose
+// grant doesn't cover the device in :address. Requests that weren't authenticated (because auth is
+// bypassed or their path is exempt) are let through
+func (d *DeviceManager) authorize(role auth.Role) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		id, ok := identity(context)
+		if !ok {
+			context.Next()
+			return
+		}
+
+		var err error
+		if !id.Role.Allows(role) {
+			err = fmt.Errorf("%w: %s has the %s role, but this needs %s", auth.ErrForbidden, id.Name, id.Role, role)
+		} else if address := context.Param("address"); address != "" {
+			dev, _ := helpers.Inventory.Lookup(address)
+			if !id.CanAccess(dev.ID, dev.Room, address) {
+				err = fmt.Errorf("%w: %s can't be used on %s", auth.ErrForbidden, id.Name, address)
+			}
+		}
+
+		if err != nil {
+			code, resp := errorResponse(context, err)
+
+			d.Log.Warn("Rejected request", zap.String("path", context.Request.URL.Path), zap.String("caller", id.Name), zap.String("requestID", resp.RequestID), zap.Error(err))
+			writeError(context, code, resp, nil)
+			return
+		}
+
+		context.Next()
+	}
+}
@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// APIKeyHeader carries a static api key
+const APIKeyHeader = "X-API-Key"
+
+// APIKey is a static key that identifies a caller
+type APIKey struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+	Grant
+}
+
+// APIKeys authenticates requests with one of a set of static keys in the X-API-Key header
+type APIKeys []APIKey
+
+// Authenticate implements Authenticator
+func (k APIKeys) Authenticate(r *http.Request) (Identity, error) {
+	key := r.Header.Get(APIKeyHeader)
+	if key == "" {
+		return Identity{}, ErrNoCredentials
+	}
+
+	// compare against every key, so that how long this takes doesn't give away which one was close
+	var found *APIKey
+	for i := range k {
+		if subtle.ConstantTimeCompare([]byte(k[i].Key), []byte(key)) == 1 && found == nil {
+			found = &k[i]
+		}
+	}
+
+	if found == nil {
+		return Identity{}, invalid("unknown api key")
+	}
+
+	return Identity{Name: found.Name, Method: "api-key", Grant: found.Grant.withDefaults()}, nil
+}
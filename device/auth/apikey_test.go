@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestAPIKeys(t *testing.T) {
+	keys := APIKeys{
+		{Name: "av-api", Key: "control-key", Grant: Grant{Role: RoleControl}},
+		{Name: "monitor", Key: "read-key"},
+	}
+
+	tests := []struct {
+		name string
+		key  string
+		want Identity
+		err  error
+	}{
+		{"control", "control-key", Identity{Name: "av-api", Method: "api-key", Grant: Grant{Role: RoleControl}}, nil},
+		{"defaults to read", "read-key", Identity{Name: "monitor", Method: "api-key", Grant: Grant{Role: RoleRead}}, nil},
+		{"unknown", "guess", Identity{}, ErrInvalidCredentials},
+		{"prefix", "control-ke", Identity{}, ErrInvalidCredentials},
+		{"missing", "", Identity{}, ErrNoCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodGet, "/", nil)
+			if tt.key != "" {
+				r.Header.Set(APIKeyHeader, tt.key)
+			}
+
+			id, err := keys.Authenticate(r)
+			if !errors.Is(err, tt.err) || (tt.err == nil && err != nil) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+
+			if id.Name != tt.want.Name || id.Method != tt.want.Method || id.Role != tt.want.Role {
+				t.Fatalf("got identity %+v, want %+v", id, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+// Package auth authenticates requests to the service, with static api keys, HMAC-signed requests
+// or JWT bearer tokens checked against a local JWKS file
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+)
+
+var (
+	// ErrNoCredentials is returned when a request doesn't carry any credentials an Authenticator understands
+	ErrNoCredentials = errors.New("no credentials")
+
+	// ErrInvalidCredentials is wrapped by errors for credentials that are present, but wrong
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Identity is who made a request
+type Identity struct {
+	Name   string `json:"name"`
+	Method string `json:"method"`
+	Grant
+}
+
+// Authenticator checks the credentials on a request. It returns ErrNoCredentials if the request
+// doesn't have any of the kind it checks, so that the next Authenticator can try
+type Authenticator interface {
+	Authenticate(r *http.Request) (Identity, error)
+}
+
+// Chain tries each Authenticator in order. The first one that finds credentials decides
+type Chain []Authenticator
+
+// Authenticate implements Authenticator
+func (c Chain) Authenticate(r *http.Request) (Identity, error) {
+	for _, a := range c {
+		id, err := a.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+
+		return id, err
+	}
+
+	return Identity{}, ErrNoCredentials
+}
+
+func invalid(format string, a ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidCredentials, fmt.Sprintf(format, a...))
+}
+
+// DefaultExempt are the paths that don't need credentials if the config doesn't say otherwise
+var DefaultExempt = []string{"/ping", "/status"}
+
+// Config is how requests are authenticated, loaded from a JSON file:
+//
+//	{
+//		"exempt": ["/ping", "/status"],
+//		"apiKeys": [{"name": "av-api", "key": "...", "role": "control"}],
+//		"hmacKeys": [{"id": "scheduler", "secret": "...", "role": "control", "rooms": ["ITB-1101"]}],
+//		"jwt": {"jwks": "/etc/sony/jwks.json", "issuer": "https://idp.byu.edu", "audience": "sony-control"}
+//	}
+//
+// Any combination of methods can be configured. Each credential has a Grant
+type Config struct {
+	path string
+
+	mu     sync.RWMutex
+	chain  Chain
+	exempt map[string]bool
+}
+
+type configFile struct {
+	Exempt   *[]string  `json:"exempt"`
+	APIKeys  []APIKey   `json:"apiKeys"`
+	HMACKeys []HMACKey  `json:"hmacKeys"`
+	JWT      *JWTConfig `json:"jwt"`
+}
+
+// Load builds a Config from the file at path
+func Load(path string) (*Config, error) {
+	c := &Config{
+		path: path,
+	}
+
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Reload rereads the config's file, and the JWKS file it points to. The current config is kept if
+// either can't be read
+func (c *Config) Reload() error {
+	if c == nil || c.path == "" {
+		return nil
+	}
+
+	b, err := os.ReadFile(c.path)
+	if err != nil {
+		return fmt.Errorf("unable to read auth config: %w", err)
+	}
+
+	var file configFile
+	if err := json.Unmarshal(b, &file); err != nil {
+		return fmt.Errorf("unable to parse auth config: %w", err)
+	}
+
+	for _, key := range file.APIKeys {
+		if !key.Role.valid() {
+			return fmt.Errorf("unable to parse auth config: api key %q has unknown role %q", key.Name, key.Role)
+		}
+	}
+
+	for _, key := range file.HMACKeys {
+		if !key.Role.valid() {
+			return fmt.Errorf("unable to parse auth config: hmac key %q has unknown role %q", key.ID, key.Role)
+		}
+	}
+
+	var chain Chain
+	if len(file.APIKeys) > 0 {
+		chain = append(chain, APIKeys(file.APIKeys))
+	}
+
+	if len(file.HMACKeys) > 0 {
+		chain = append(chain, HMACKeys(file.HMACKeys))
+	}
+
+	if file.JWT != nil {
+		verifier, err := NewJWTVerifier(*file.JWT)
+		if err != nil {
+			return err
+		}
+
+		chain = append(chain, verifier)
+	}
+
+	if len(chain) == 0 {
+		return errors.New("auth config doesn't configure any authentication methods")
+	}
+
+	exempt := DefaultExempt
+	if file.Exempt != nil {
+		exempt = *file.Exempt
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.chain = chain
+	c.exempt = make(map[string]bool, len(exempt))
+	for _, path := range exempt {
+		c.exempt[path] = true
+	}
+
+	return nil
+}
+
+// Authenticate implements Authenticator
+func (c *Config) Authenticate(r *http.Request) (Identity, error) {
+	c.mu.RLock()
+	chain := c.chain
+	c.mu.RUnlock()
+
+	return chain.Authenticate(r)
+}
+
+// Exempt reports whether requests to path don't need credentials
+func (c *Config) Exempt(path string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.exempt[path]
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers of an HMAC-signed request
+const (
+	HMACKeyIDHeader     = "X-Auth-Key-ID"
+	HMACTimestampHeader = "X-Auth-Timestamp"
+	HMACSignatureHeader = "X-Auth-Signature"
+)
+
+// hmacMaxSkew is how far a signed request's timestamp can be from our clock
+const hmacMaxSkew = 5 * time.Minute
+
+// MaxBodySize is the largest body HMACKeys will read to check a request's signature. The key id and
+// timestamp aren't secret, so without a limit anyone could make the service buffer whatever they send
+const MaxBodySize = 64 << 10
+
+// HMACKey is a shared secret a caller signs requests with
+type HMACKey struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+	Grant
+}
+
+// HMACKeys authenticates requests signed with one of a set of shared secrets. A signed request has:
+//
+//	X-Auth-Key-ID: the id of the key
+//	X-Auth-Timestamp: the current unix time, in seconds
+//	X-Auth-Signature: hex(hmac-sha256(secret, method + "\n" + request uri + "\n" + timestamp + "\n" + hex(sha256(body))))
+type HMACKeys []HMACKey
+
+// Authenticate implements Authenticator
+func (k HMACKeys) Authenticate(r *http.Request) (Identity, error) {
+	id := r.Header.Get(HMACKeyIDHeader)
+	if id == "" {
+		return Identity{}, ErrNoCredentials
+	}
+
+	var found *HMACKey
+	for i := range k {
+		if k[i].ID == id {
+			found = &k[i]
+			break
+		}
+	}
+
+	if found == nil || found.Secret == "" {
+		return Identity{}, invalid("unknown hmac key %q", id)
+	}
+
+	timestamp := r.Header.Get(HMACTimestampHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return Identity{}, invalid("invalid %s", HMACTimestampHeader)
+	}
+
+	skew := time.Since(time.Unix(unix, 0))
+	if skew > hmacMaxSkew || skew < -hmacMaxSkew {
+		return Identity{}, invalid("request was signed too long ago")
+	}
+
+	signature, err := hex.DecodeString(r.Header.Get(HMACSignatureHeader))
+	if err != nil {
+		return Identity{}, invalid("invalid %s", HMACSignatureHeader)
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, err = io.ReadAll(io.LimitReader(r.Body, MaxBodySize+1))
+		if err != nil {
+			return Identity{}, invalid("unable to read body: %s", err)
+		}
+
+		if len(body) > MaxBodySize {
+			return Identity{}, invalid("body is larger than %d bytes", MaxBodySize)
+		}
+
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	if !hmac.Equal(signature, SignHMAC(found.Secret, r.Method, r.URL.RequestURI(), timestamp, body)) {
+		return Identity{}, invalid("signature doesn't match")
+	}
+
+	return Identity{Name: id, Method: "hmac", Grant: found.Grant.withDefaults()}, nil
+}
+
+// SignHMAC returns the signature for a request, as described on HMACKeys
+func SignHMAC(secret, method, requestURI, timestamp string, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + requestURI + "\n" + timestamp + "\n" + hex.EncodeToString(bodyHash[:])))
+
+	return mac.Sum(nil)
+}
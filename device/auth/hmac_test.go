@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHMACKeys(t *testing.T) {
+	keys := HMACKeys{
+		{ID: "scheduler", Secret: "s3cret", Grant: Grant{Role: RoleControl, Rooms: []string{"ITB-1101"}}},
+		{ID: "disabled"},
+	}
+
+	const body = `{"power": "on"}`
+	const uri = "/v2/10.0.0.1/state?fresh=true"
+
+	// signed builds a request for uri with body, signed with secret at the given time. change, if it isn't
+	// nil, can change the request after it's signed
+	signed := func(id, secret string, at time.Time, change func(r *http.Request)) *http.Request {
+		timestamp := strconv.FormatInt(at.Unix(), 10)
+
+		r, _ := http.NewRequest(http.MethodPut, uri, strings.NewReader(body))
+		r.Header.Set(HMACKeyIDHeader, id)
+		r.Header.Set(HMACTimestampHeader, timestamp)
+		r.Header.Set(HMACSignatureHeader, hex.EncodeToString(SignHMAC(secret, http.MethodPut, uri, timestamp, []byte(body))))
+
+		if change != nil {
+			change(r)
+		}
+
+		return r
+	}
+
+	now := time.Now()
+	tests := []struct {
+		name string
+		r    *http.Request
+		ok   bool
+	}{
+		{"signed", signed("scheduler", "s3cret", now, nil), true},
+		{"small skew", signed("scheduler", "s3cret", now.Add(-4*time.Minute), nil), true},
+		{"small skew ahead", signed("scheduler", "s3cret", now.Add(4*time.Minute), nil), true},
+
+		{"too old", signed("scheduler", "s3cret", now.Add(-6*time.Minute), nil), false},
+		{"too far ahead", signed("scheduler", "s3cret", now.Add(6*time.Minute), nil), false},
+		{"wrong secret", signed("scheduler", "guess", now, nil), false},
+		{"unknown key", signed("nobody", "s3cret", now, nil), false},
+		{"key without a secret", signed("disabled", "", now, nil), false},
+		{"tampered body", signed("scheduler", "s3cret", now, func(r *http.Request) {
+			r.Body = io.NopCloser(strings.NewReader(`{"power": "standby"}`))
+		}), false},
+		{"tampered uri", signed("scheduler", "s3cret", now, func(r *http.Request) {
+			r.URL.RawQuery = "fresh=false"
+		}), false},
+		{"tampered method", signed("scheduler", "s3cret", now, func(r *http.Request) {
+			r.Method = http.MethodPost
+		}), false},
+		{"replayed with a new timestamp", signed("scheduler", "s3cret", now.Add(-10*time.Minute), func(r *http.Request) {
+			r.Header.Set(HMACTimestampHeader, strconv.FormatInt(now.Unix(), 10))
+		}), false},
+		{"bad timestamp", signed("scheduler", "s3cret", now, func(r *http.Request) {
+			r.Header.Set(HMACTimestampHeader, "yesterday")
+		}), false},
+		{"bad signature", signed("scheduler", "s3cret", now, func(r *http.Request) {
+			r.Header.Set(HMACSignatureHeader, "not hex")
+		}), false},
+		{"body too large", signed("scheduler", "s3cret", now, func(r *http.Request) {
+			r.Body = io.NopCloser(strings.NewReader(strings.Repeat("x", MaxBodySize+1)))
+		}), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := keys.Authenticate(tt.r)
+
+			switch {
+			case tt.ok && err != nil:
+				t.Fatalf("request was rejected: %s", err)
+			case !tt.ok && err == nil:
+				t.Fatalf("request was accepted as %+v", id)
+			case !tt.ok && !errors.Is(err, ErrInvalidCredentials):
+				t.Fatalf("got error %s, want %s", err, ErrInvalidCredentials)
+			case tt.ok && (id.Name != "scheduler" || id.Role != RoleControl || id.Method != "hmac"):
+				t.Fatalf("got identity %+v", id)
+			}
+
+			if tt.ok {
+				// the body has to still be there for the handler
+				b, _ := io.ReadAll(tt.r.Body)
+				if string(b) != body {
+					t.Fatalf("got body %q after authenticating, want %q", b, body)
+				}
+			}
+		})
+	}
+
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	if _, err := keys.Authenticate(r); !errors.Is(err, ErrNoCredentials) {
+		t.Fatalf("got %v for an unsigned request, want %s", err, ErrNoCredentials)
+	}
+}
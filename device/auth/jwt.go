@@ -0,0 +1,296 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // registers the hashes used by RS256 and ES256
+	_ "crypto/sha512" // registers the hashes used by RS384, RS512 and ES384
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// jwtLeeway is how much clock skew is tolerated when checking exp and nbf
+const jwtLeeway = time.Minute
+
+// JWTConfig is where to find the keys that sign our bearer tokens, and what the tokens must say
+type JWTConfig struct {
+	// JWKS is the path to a JSON Web Key Set file
+	JWKS string `json:"jwks"`
+
+	// Issuer and Audience, if set, must match the token's iss and aud claims
+	Issuer   string `json:"issuer,omitempty"`
+	Audience string `json:"audience,omitempty"`
+}
+
+// JWTVerifier authenticates requests with a bearer token signed by one of the keys in a JWKS file.
+// RS256, RS384, RS512, ES256 and ES384 tokens are supported. The token's grant comes from its role,
+// devices and rooms claims
+type JWTVerifier struct {
+	config JWTConfig
+	keys   map[string]crypto.PublicKey
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+
+	// rsa
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// ec
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// NewJWTVerifier loads the keys in config's JWKS file
+func NewJWTVerifier(config JWTConfig) (*JWTVerifier, error) {
+	b, err := os.ReadFile(config.JWKS)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read jwks: %w", err)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, fmt.Errorf("unable to parse jwks: %w", err)
+	}
+
+	v := &JWTVerifier{
+		config: config,
+		keys:   make(map[string]crypto.PublicKey),
+	}
+
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+
+		pub, err := key.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse jwk %q: %w", key.Kid, err)
+		}
+
+		v.keys[key.Kid] = pub
+	}
+
+	if len(v.keys) == 0 {
+		return nil, fmt.Errorf("no signing keys in %s", config.JWKS)
+	}
+
+	return v, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *int64          `json:"exp"`
+	NotBefore *int64          `json:"nbf"`
+	Grant
+}
+
+// Authenticate implements Authenticator
+func (v *JWTVerifier) Authenticate(r *http.Request) (Identity, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return Identity{}, ErrNoCredentials
+	}
+
+	token := strings.TrimPrefix(header, "Bearer ")
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Identity{}, invalid("malformed token")
+	}
+
+	var head jwtHeader
+	if err := decodeSegment(parts[0], &head); err != nil {
+		return Identity{}, invalid("malformed token header")
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Identity{}, invalid("malformed token signature")
+	}
+
+	if err := v.verify(head, parts[0]+"."+parts[1], sig); err != nil {
+		return Identity{}, err
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Identity{}, invalid("malformed token claims")
+	}
+
+	if err := v.checkClaims(claims); err != nil {
+		return Identity{}, err
+	}
+
+	return Identity{Name: claims.Subject, Method: "jwt", Grant: claims.Grant.withDefaults()}, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+func (v *JWTVerifier) verify(head jwtHeader, signed string, sig []byte) error {
+	key, ok := v.keys[head.Kid]
+	if !ok && head.Kid == "" && len(v.keys) == 1 {
+		for _, k := range v.keys {
+			key, ok = k, true
+		}
+	}
+
+	if !ok {
+		return invalid("unknown signing key %q", head.Kid)
+	}
+
+	var hash crypto.Hash
+	switch head.Alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512":
+		hash = crypto.SHA512
+	default:
+		return invalid("unsupported signing algorithm %q", head.Alg)
+	}
+
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(head.Alg, "RS") || rsa.VerifyPKCS1v15(key, hash, digest, sig) != nil {
+			return invalid("bad token signature")
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(head.Alg, "ES") || len(sig) != 2*size {
+			return invalid("bad token signature")
+		}
+
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return invalid("bad token signature")
+		}
+	default:
+		return invalid("unsupported signing key %q", head.Kid)
+	}
+
+	return nil
+}
+
+func (v *JWTVerifier) checkClaims(claims jwtClaims) error {
+	now := time.Now()
+
+	if claims.ExpiresAt == nil || now.After(time.Unix(*claims.ExpiresAt, 0).Add(jwtLeeway)) {
+		return invalid("token is expired")
+	}
+
+	if claims.NotBefore != nil && now.Before(time.Unix(*claims.NotBefore, 0).Add(-jwtLeeway)) {
+		return invalid("token isn't valid yet")
+	}
+
+	if v.config.Issuer != "" && claims.Issuer != v.config.Issuer {
+		return invalid("token has the wrong issuer")
+	}
+
+	if v.config.Audience != "" {
+		var audiences []string
+		if err := json.Unmarshal(claims.Audience, &audiences); err != nil {
+			var audience string
+			if json.Unmarshal(claims.Audience, &audience) == nil {
+				audiences = []string{audience}
+			}
+		}
+
+		found := false
+		for _, aud := range audiences {
+			if aud == v.config.Audience {
+				found = true
+			}
+		}
+
+		if !found {
+			return invalid("token has the wrong audience")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,253 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testKeys are the keys in the JWKS the tests verify tokens with
+type testKeys struct {
+	rsa   *rsa.PrivateKey
+	ec    *ecdsa.PrivateKey
+	other *rsa.PrivateKey
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate rsa key: %s", err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate ec key: %s", err)
+	}
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate rsa key: %s", err)
+	}
+
+	return testKeys{rsa: rsaKey, ec: ecKey, other: other}
+}
+
+func encodeBigInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+// newTestVerifier writes a JWKS with the rsa key (as "rsa") and the ec key (as "ec") and loads it
+func newTestVerifier(t *testing.T, keys testKeys, config JWTConfig) *JWTVerifier {
+	t.Helper()
+
+	set := map[string]interface{}{
+		"keys": []jwk{
+			{Kty: "RSA", Kid: "rsa", Use: "sig", N: encodeBigInt(keys.rsa.N), E: encodeBigInt(big.NewInt(int64(keys.rsa.E)))},
+			{Kty: "EC", Kid: "ec", Crv: "P-256", X: encodeBigInt(keys.ec.X), Y: encodeBigInt(keys.ec.Y)},
+		},
+	}
+
+	b, err := json.Marshal(set)
+	if err != nil {
+		t.Fatalf("unable to encode jwks: %s", err)
+	}
+
+	config.JWKS = filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(config.JWKS, b, 0600); err != nil {
+		t.Fatalf("unable to write jwks: %s", err)
+	}
+
+	v, err := NewJWTVerifier(config)
+	if err != nil {
+		t.Fatalf("unable to load jwks: %s", err)
+	}
+
+	return v
+}
+
+func encodeSegment(t *testing.T, v interface{}) string {
+	t.Helper()
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("unable to encode token segment: %s", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// signToken builds a token with header and claims, signed with key using alg. key is an
+// *rsa.PrivateKey, an *ecdsa.PrivateKey, a []byte hmac secret, or nil for no signature
+func signToken(t *testing.T, header map[string]string, claims map[string]interface{}, key interface{}) string {
+	t.Helper()
+
+	signed := encodeSegment(t, header) + "." + encodeSegment(t, claims)
+
+	hash := crypto.SHA256
+	switch header["alg"] {
+	case "RS384", "ES384", "HS384":
+		hash = crypto.SHA384
+	case "RS512", "HS512":
+		hash = crypto.SHA512
+	}
+
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	var sig []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key, hash, digest)
+		if err != nil {
+			t.Fatalf("unable to sign token: %s", err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+		if err != nil {
+			t.Fatalf("unable to sign token: %s", err)
+		}
+
+		size := (key.Curve.Params().BitSize + 7) / 8
+		sig = make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func bearer(token string) *http.Request {
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	return r
+}
+
+func TestJWTVerifier(t *testing.T) {
+	keys := newTestKeys(t)
+	v := newTestVerifier(t, keys, JWTConfig{Issuer: "https://idp.example.com", Audience: "sony-control"})
+
+	now := time.Now().Unix()
+	claims := func(change func(c map[string]interface{})) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub":  "scheduler",
+			"iss":  "https://idp.example.com",
+			"aud":  "sony-control",
+			"exp":  now + 300,
+			"role": "control",
+		}
+
+		if change != nil {
+			change(c)
+		}
+
+		return c
+	}
+
+	rs256 := map[string]string{"alg": "RS256", "kid": "rsa"}
+	es256 := map[string]string{"alg": "ES256", "kid": "ec"}
+
+	// the public key's modulus is public, so an attacker could use it as an hmac secret
+	rsaAsSecret := keys.rsa.N.Bytes()
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"rs256", signToken(t, rs256, claims(nil), keys.rsa), true},
+		{"rs384", signToken(t, map[string]string{"alg": "RS384", "kid": "rsa"}, claims(nil), keys.rsa), true},
+		{"rs512", signToken(t, map[string]string{"alg": "RS512", "kid": "rsa"}, claims(nil), keys.rsa), true},
+		{"es256", signToken(t, es256, claims(nil), keys.ec), true},
+
+		{"signed by another key", signToken(t, rs256, claims(nil), keys.other), false},
+		{"unknown kid", signToken(t, map[string]string{"alg": "RS256", "kid": "nope"}, claims(nil), keys.rsa), false},
+		{"alg none", signToken(t, map[string]string{"alg": "none", "kid": "rsa"}, claims(nil), nil), false},
+		{"hs256 with the rsa key", signToken(t, map[string]string{"alg": "HS256", "kid": "rsa"}, claims(nil), rsaAsSecret), false},
+		{"es256 with the rsa key", signToken(t, map[string]string{"alg": "ES256", "kid": "rsa"}, claims(nil), keys.ec), false},
+		{"rs256 with the ec key", signToken(t, map[string]string{"alg": "RS256", "kid": "ec"}, claims(nil), keys.rsa), false},
+
+		{"expired within leeway", signToken(t, rs256, claims(func(c map[string]interface{}) { c["exp"] = now - 30 }), keys.rsa), true},
+		{"expired", signToken(t, rs256, claims(func(c map[string]interface{}) { c["exp"] = now - 120 }), keys.rsa), false},
+		{"no exp", signToken(t, rs256, claims(func(c map[string]interface{}) { delete(c, "exp") }), keys.rsa), false},
+		{"nbf within leeway", signToken(t, rs256, claims(func(c map[string]interface{}) { c["nbf"] = now + 30 }), keys.rsa), true},
+		{"nbf in the future", signToken(t, rs256, claims(func(c map[string]interface{}) { c["nbf"] = now + 120 }), keys.rsa), false},
+
+		{"aud array", signToken(t, rs256, claims(func(c map[string]interface{}) { c["aud"] = []string{"other", "sony-control"} }), keys.rsa), true},
+		{"wrong aud", signToken(t, rs256, claims(func(c map[string]interface{}) { c["aud"] = "other" }), keys.rsa), false},
+		{"wrong aud array", signToken(t, rs256, claims(func(c map[string]interface{}) { c["aud"] = []string{"other"} }), keys.rsa), false},
+		{"no aud", signToken(t, rs256, claims(func(c map[string]interface{}) { delete(c, "aud") }), keys.rsa), false},
+		{"wrong iss", signToken(t, rs256, claims(func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }), keys.rsa), false},
+
+		{"malformed", "not.a-token", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := v.Authenticate(bearer(tt.token))
+
+			switch {
+			case tt.ok && err != nil:
+				t.Fatalf("token was rejected: %s", err)
+			case !tt.ok && err == nil:
+				t.Fatalf("token was accepted as %+v", id)
+			case !tt.ok && !errors.Is(err, ErrInvalidCredentials):
+				t.Fatalf("got error %s, want %s", err, ErrInvalidCredentials)
+			case tt.ok && (id.Name != "scheduler" || id.Role != RoleControl || id.Method != "jwt"):
+				t.Fatalf("got identity %+v", id)
+			}
+		})
+	}
+}
+
+func TestJWTTamperedClaims(t *testing.T) {
+	keys := newTestKeys(t)
+	v := newTestVerifier(t, keys, JWTConfig{})
+
+	header := map[string]string{"alg": "RS256", "kid": "rsa"}
+	exp := time.Now().Add(time.Hour).Unix()
+	token := signToken(t, header, map[string]interface{}{"sub": "monitor", "exp": exp, "role": "read"}, keys.rsa)
+
+	if _, err := v.Authenticate(bearer(token)); err != nil {
+		t.Fatalf("untampered token was rejected: %s", err)
+	}
+
+	// swap in claims that give the token admin, keeping the original signature
+	parts := strings.Split(token, ".")
+	forged := parts[0] + "." + encodeSegment(t, map[string]interface{}{"sub": "monitor", "exp": exp, "role": "admin"}) + "." + parts[2]
+
+	if id, err := v.Authenticate(bearer(forged)); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("tampered token got %+v, %v; want it rejected", id, err)
+	}
+}
+
+func TestJWTNoCredentials(t *testing.T) {
+	v := newTestVerifier(t, newTestKeys(t), JWTConfig{})
+
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	if _, err := v.Authenticate(r); !errors.Is(err, ErrNoCredentials) {
+		t.Fatalf("got %v for a request without a token, want %s", err, ErrNoCredentials)
+	}
+}
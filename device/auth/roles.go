@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrForbidden is wrapped by errors for requests the caller's role or scope doesn't allow
+var ErrForbidden = errors.New("forbidden")
+
+// Role is what a credential is allowed to do. Each role can do everything the roles below it can
+type Role string
+
+const (
+	// RoleRead can read the status of devices, e.g. for monitoring
+	RoleRead Role = "read"
+
+	// RoleControl can also change devices, e.g. turn them on or switch their input
+	RoleControl Role = "control"
+
+	// RoleAdmin can also use admin and diagnostic features
+	RoleAdmin Role = "admin"
+)
+
+var roleRanks = map[Role]int{
+	RoleRead:    1,
+	RoleControl: 2,
+	RoleAdmin:   3,
+}
+
+// Allows reports whether r can do what required can. Unknown roles can't do anything
+func (r Role) Allows(required Role) bool {
+	rank, ok := roleRanks[r]
+	return ok && rank >= roleRanks[required]
+}
+
+func (r Role) valid() bool {
+	_, ok := roleRanks[r]
+	return ok || r == ""
+}
+
+// Grant is the role a credential has, and optionally, the only devices it can be used on
+type Grant struct {
+	// Role defaults to RoleRead
+	Role Role `json:"role,omitempty"`
+
+	// Devices are inventory ids or addresses, and Rooms are inventory rooms, e.g. ITB-1101.
+	// If neither is set, the credential can be used on every device
+	Devices []string `json:"devices,omitempty"`
+	Rooms   []string `json:"rooms,omitempty"`
+}
+
+func (g Grant) withDefaults() Grant {
+	if g.Role == "" {
+		g.Role = RoleRead
+	}
+
+	return g
+}
+
+// CanAccess reports whether the grant covers a device, given its inventory id and room (if it's in
+// the inventory) and its address
+func (g Grant) CanAccess(id, room, address string) bool {
+	if len(g.Devices) == 0 && len(g.Rooms) == 0 {
+		return true
+	}
+
+	for _, dev := range g.Devices {
+		if (id != "" && strings.EqualFold(dev, id)) || strings.EqualFold(dev, address) {
+			return true
+		}
+	}
+
+	for _, r := range g.Rooms {
+		if room != "" && strings.EqualFold(r, room) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,139 @@
+package device_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/byuoitav/sony-control-microservice/device"
+	"github.com/byuoitav/sony-control-microservice/device/auth"
+	"github.com/byuoitav/sony-control-microservice/device/inventory"
+	"github.com/gin-gonic/gin"
+)
+
+// authConfig is the keys the authorization tests use. The simulated TV is ITB-1101-D1
+const authConfig = `{
+	"apiKeys": [
+		{"name": "monitor", "key": "read-key", "role": "read"},
+		{"name": "av-api", "key": "control-key", "role": "control"},
+		{"name": "ops", "key": "admin-key", "role": "admin"},
+		{"name": "d1", "key": "d1-key", "role": "control", "devices": ["ITB-1101-D1"]},
+		{"name": "room", "key": "room-key", "role": "control", "rooms": ["ITB-1101"]},
+		{"name": "other-room", "key": "other-room-key", "role": "admin", "rooms": ["ITB-1102"]}
+	]
+}`
+
+// otherAddress is the address of ITB-1102-D1. Nothing listens on it, so requests that get past
+// authorization fail, rather than succeed
+const otherAddress = "127.0.0.1:1"
+
+// newAuthService is newTestService with authConfig's keys required, and an inventory with the
+// simulated TV and one in another room
+func newAuthService(t *testing.T) *testService {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "auth.json")
+	if err := os.WriteFile(path, []byte(authConfig), 0600); err != nil {
+		t.Fatalf("unable to write auth config: %s", err)
+	}
+
+	config, err := auth.Load(path)
+	if err != nil {
+		t.Fatalf("unable to load auth config: %s", err)
+	}
+
+	s := newTestService(t, func(d *device.DeviceManager, router *gin.Engine) {
+		router.Use(d.Authenticate(config))
+	})
+
+	useInventory(t, map[string]inventory.Device{
+		"ITB-1101-D1": {Address: s.address},
+		"ITB-1102-D1": {Address: otherAddress},
+	})
+
+	return s
+}
+
+func TestAuthorization(t *testing.T) {
+	s := newAuthService(t)
+
+	tests := []struct {
+		key    string
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		{"", http.MethodGet, "/:address/volume/level", "", http.StatusUnauthorized},
+		{"wrong-key", http.MethodGet, "/:address/volume/level", "", http.StatusUnauthorized},
+
+		// roles
+		{"read-key", http.MethodGet, "/:address/volume/level", "", http.StatusOK},
+		{"read-key", http.MethodGet, "/v2/:address/volume", "", http.StatusOK},
+		{"read-key", http.MethodGet, "/:address/volume/set/10", "", http.StatusForbidden},
+		{"read-key", http.MethodPut, "/v2/:address/volume", `{"volume": 10}`, http.StatusForbidden},
+		{"read-key", http.MethodPut, "/:address/state", `{"muted": true}`, http.StatusForbidden},
+		{"read-key", http.MethodGet, "/:address/power/standby?async=true", "", http.StatusForbidden},
+		{"control-key", http.MethodGet, "/:address/volume/set/10", "", http.StatusOK},
+		{"control-key", http.MethodPut, "/v2/:address/volume", `{"volume": 10}`, http.StatusOK},
+		{"control-key", http.MethodGet, "/audit", "", http.StatusForbidden},
+		{"control-key", http.MethodGet, "/v2/audit", "", http.StatusForbidden},
+		{"control-key", http.MethodGet, "/:address/remote/Home", "", http.StatusForbidden},
+		{"admin-key", http.MethodGet, "/:address/remote/Home", "", http.StatusOK},
+
+		// a key for one device
+		{"d1-key", http.MethodGet, "/:address/volume/set/10", "", http.StatusOK},
+		{"d1-key", http.MethodGet, "/ITB-1101-D1/volume/level", "", http.StatusOK},
+		{"d1-key", http.MethodGet, "/itb-1101-d1/volume/level", "", http.StatusOK},
+		{"d1-key", http.MethodGet, "/ITB-1102-D1/volume/level", "", http.StatusForbidden},
+		{"d1-key", http.MethodGet, "/" + otherAddress + "/volume/level", "", http.StatusForbidden},
+		{"d1-key", http.MethodGet, "/10.9.9.9/volume/level", "", http.StatusForbidden},
+
+		// a key for one room
+		{"room-key", http.MethodGet, "/ITB-1101-D1/volume/set/10", "", http.StatusOK},
+		{"room-key", http.MethodGet, "/:address/volume/level", "", http.StatusOK},
+		{"room-key", http.MethodPut, "/v2/ITB-1102-D1/volume", `{"volume": 10}`, http.StatusForbidden},
+		{"room-key", http.MethodGet, "/" + otherAddress + "/volume/level", "", http.StatusForbidden},
+		{"room-key", http.MethodGet, "/10.9.9.9/volume/level", "", http.StatusForbidden},
+		{"other-room-key", http.MethodGet, "/:address/remote/Home", "", http.StatusForbidden},
+		{"other-room-key", http.MethodGet, "/ITB-1101-D1/volume/level", "", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		what := strings.TrimSpace(tt.key + " " + tt.method + " " + tt.path)
+
+		var resp device.Response
+		code := s.as(tt.key).do(tt.method, tt.path, tt.body, &resp)
+		expect(t, what, code, tt.code)
+
+		switch tt.code {
+		case http.StatusUnauthorized:
+			expectError(t, what, resp, device.ErrCodeUnauthorized)
+		case http.StatusForbidden:
+			expectError(t, what, resp, device.ErrCodeForbidden)
+		}
+	}
+}
+
+func TestJobScope(t *testing.T) {
+	s := newAuthService(t)
+
+	var job device.Job
+	expect(t, "async standby", s.as("control-key").get("/:address/power/standby?async=true", &job), http.StatusAccepted)
+
+	// a job on a device the caller can't use looks like it doesn't exist
+	for _, path := range []string{"/jobs/" + job.ID, "/v2/jobs/" + job.ID} {
+		var resp device.Response
+		expect(t, "other room "+path, s.as("other-room-key").get(path, &resp), http.StatusNotFound)
+		expectError(t, "other room "+path, resp, device.ErrCodeNotFound)
+
+		expect(t, "d1 "+path, s.as("d1-key").get(path, nil), http.StatusOK)
+		expect(t, "room "+path, s.as("room-key").get(path, nil), http.StatusOK)
+	}
+
+	if job = s.as("read-key").waitForJob(job); job.Status != device.JobSucceeded {
+		t.Fatalf("job %s, want it to have succeeded", job.Status)
+	}
+}
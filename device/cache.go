@@ -0,0 +1,227 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/byuoitav/common/status"
+	"github.com/byuoitav/sony-control-microservice/device/helpers"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/singleflight"
+)
+
+// Fields of a device's state that are cached
+const (
+	FieldPower   = "power"
+	FieldInput   = "input"
+	FieldAudio   = "audio"
+	FieldBlanked = "blanked"
+
+	// FieldIdentity doesn't change when the TV's state does, so actions don't invalidate it
+	FieldIdentity = "identity"
+)
+
+// allFields is every cached field
+var allFields = []string{FieldPower, FieldInput, FieldAudio, FieldBlanked}
+
+const (
+	// DefaultCacheTTL is used when DeviceManager.CacheTTL isn't set
+	DefaultCacheTTL = 2 * time.Second
+
+	// defaultIdentityTTL is used for FieldIdentity unless it's set in DeviceManager.CacheTTLs
+	defaultIdentityTTL = time.Hour
+)
+
+// audioState is the cached result of getVolumeInformation
+type audioState struct {
+	Volume status.Volume
+	Mute   status.Mute
+}
+
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// statusCache holds recent reads of each device's state. Concurrent reads of the same
+// field share a single request to the TV
+type statusCache struct {
+	mu          sync.Mutex
+	entries     map[string]cacheEntry
+	generations map[string]uint64
+	group       singleflight.Group
+}
+
+func cacheKey(address, field string) string {
+	return address + "|" + field
+}
+
+// generation returns the number of times address has been invalidated
+func (c *statusCache) generation(address string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generations[address]
+}
+
+func (c *statusCache) get(address, field string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[cacheKey(address, field)]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+
+	return entry.value, true
+}
+
+// snapshot returns every field of address that's cached, or nil if none of them are
+func (c *statusCache) snapshot(address string) map[string]interface{} {
+	var fields map[string]interface{}
+	for _, field := range allFields {
+		if value, ok := c.get(address, field); ok {
+			if fields == nil {
+				fields = make(map[string]interface{})
+			}
+
+			fields[field] = value
+		}
+	}
+
+	return fields
+}
+
+// set stores value unless address has been invalidated since gen, in which case value may be stale
+func (c *statusCache) set(address, field string, gen uint64, value interface{}, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ttl <= 0 || c.generations[address] != gen {
+		return
+	}
+
+	if c.entries == nil {
+		c.entries = make(map[string]cacheEntry)
+	}
+
+	c.entries[cacheKey(address, field)] = cacheEntry{
+		value:   value,
+		expires: time.Now().Add(ttl),
+	}
+}
+
+// invalidate drops the cached fields for address, and stops any reads that are already
+// in flight from being cached
+func (c *statusCache) invalidate(address string, fields ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generations == nil {
+		c.generations = make(map[string]uint64)
+	}
+
+	c.generations[address]++
+	for _, field := range fields {
+		delete(c.entries, cacheKey(address, field))
+	}
+}
+
+// ttl returns how long field should be cached for
+func (d *DeviceManager) ttl(field string) time.Duration {
+	if ttl, ok := d.CacheTTLs[field]; ok {
+		return ttl
+	}
+
+	if field == FieldIdentity {
+		return defaultIdentityTTL
+	}
+
+	if d.CacheTTL != 0 {
+		return d.CacheTTL
+	}
+
+	return DefaultCacheTTL
+}
+
+// cached returns field for address from the cache, or reads it with read. Unless fresh is set,
+// concurrent callers share a single read, which isn't cancelled if the caller that started it goes away
+func cached[T any](ctx context.Context, d *DeviceManager, address, field string, fresh bool, read func(context.Context) (T, error)) (T, error) {
+	gen := d.cache.generation(address)
+
+	if fresh {
+		value, err := read(ctx)
+		if err == nil {
+			d.cache.set(address, field, gen, value, d.ttl(field))
+		}
+
+		return value, err
+	}
+
+	if value, ok := d.cache.get(address, field); ok {
+		return value.(T), nil
+	}
+
+	key := fmt.Sprintf("%s|%d", cacheKey(address, field), gen)
+	value, err, _ := d.cache.group.Do(key, func() (interface{}, error) {
+		value, err := read(context.WithoutCancel(ctx))
+		if err != nil {
+			return value, err
+		}
+
+		d.cache.set(address, field, gen, value, d.ttl(field))
+		return value, nil
+	})
+
+	return value.(T), err
+}
+
+// isFresh returns true if the request asked to skip the cache with ?fresh=true
+func isFresh(context *gin.Context) bool {
+	fresh, _ := strconv.ParseBool(context.Query("fresh"))
+	return fresh
+}
+
+func (d *DeviceManager) readPower(ctx context.Context, address string, fresh bool) (status.Power, error) {
+	return cached(ctx, d, address, FieldPower, fresh, func(ctx context.Context) (status.Power, error) {
+		return helpers.GetPower(ctx, address)
+	})
+}
+
+func (d *DeviceManager) readInput(ctx context.Context, address string, fresh bool) (status.Input, error) {
+	return cached(ctx, d, address, FieldInput, fresh, func(ctx context.Context) (status.Input, error) {
+		power, err := d.readPower(ctx, address, fresh)
+		if err != nil {
+			return status.Input{}, err
+		}
+
+		// the tv doesn't report an input while it's in standby
+		if power.Power != "on" {
+			return status.Input{}, nil
+		}
+
+		return helpers.GetCurrentInput(ctx, address, d)
+	})
+}
+
+func (d *DeviceManager) readAudio(ctx context.Context, address string, fresh bool) (audioState, error) {
+	return cached(ctx, d, address, FieldAudio, fresh, func(ctx context.Context) (audioState, error) {
+		volume, mute, err := helpers.GetAudio(ctx, address, d)
+		return audioState{Volume: volume, Mute: mute}, err
+	})
+}
+
+func (d *DeviceManager) readIdentity(ctx context.Context, address string, fresh bool) (helpers.Identity, error) {
+	return cached(ctx, d, address, FieldIdentity, fresh, func(ctx context.Context) (helpers.Identity, error) {
+		return helpers.GetIdentity(ctx, address, d)
+	})
+}
+
+func (d *DeviceManager) readBlanked(ctx context.Context, address string, fresh bool) (status.Blanked, error) {
+	return cached(ctx, d, address, FieldBlanked, fresh, func(ctx context.Context) (status.Blanked, error) {
+		return helpers.GetBlanked(ctx, address, d)
+	})
+}
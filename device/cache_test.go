@@ -0,0 +1,103 @@
+package device_test
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/byuoitav/sony-control-microservice/device"
+	"github.com/byuoitav/sony-control-microservice/simulator"
+	"github.com/gin-gonic/gin"
+)
+
+// newCachingService is newTestService with status reads cached for a minute, unless ttls says otherwise
+func newCachingService(t *testing.T, ttls map[string]time.Duration) *testService {
+	t.Helper()
+
+	return newTestService(t, func(d *device.DeviceManager, _ *gin.Engine) {
+		d.CacheTTL = time.Minute
+		d.CacheTTLs = ttls
+	})
+}
+
+// expectCalls fails the test unless the simulated TV has had n calls to method
+func (s *testService) expectCalls(method string, n int) {
+	s.t.Helper()
+
+	if got := s.tv.Calls(method); got != n {
+		s.t.Fatalf("tv got %d calls to %s, want %d", got, method, n)
+	}
+}
+
+func TestCacheHit(t *testing.T) {
+	s := newCachingService(t, nil)
+
+	for i := 0; i < 3; i++ {
+		expect(t, "volume level", s.get("/:address/volume/level", nil), http.StatusOK)
+	}
+
+	s.expectCalls("getVolumeInformation", 1)
+
+	expect(t, "fresh volume level", s.get("/:address/volume/level?fresh=true", nil), http.StatusOK)
+	s.expectCalls("getVolumeInformation", 2)
+}
+
+func TestCacheClearedByAction(t *testing.T) {
+	s := newCachingService(t, nil)
+
+	var volume struct{ Volume int }
+	expect(t, "volume level", s.get("/:address/volume/level", &volume), http.StatusOK)
+	if volume.Volume != 20 {
+		t.Fatalf("got volume %d, want 20", volume.Volume)
+	}
+
+	expect(t, "set volume", s.get("/:address/volume/set/30", nil), http.StatusOK)
+
+	// the cached 20 would still be good for a minute if the action hadn't cleared it
+	calls := s.tv.Calls("getVolumeInformation")
+	expect(t, "volume level", s.get("/:address/volume/level", &volume), http.StatusOK)
+	if volume.Volume != 30 {
+		t.Fatalf("got volume %d after setting it to 30", volume.Volume)
+	}
+
+	s.expectCalls("getVolumeInformation", calls+1)
+}
+
+func TestCacheSharesReads(t *testing.T) {
+	s := newCachingService(t, nil)
+
+	// slow the tv down, so that every request is waiting on it at the same time
+	s.tv.SetFaults(simulator.Faults{Latency: simulator.Duration(300 * time.Millisecond)})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if code := s.get("/:address/volume/level", nil); code != http.StatusOK {
+				t.Errorf("volume level: got status %d, want %d", code, http.StatusOK)
+			}
+		}()
+	}
+
+	wg.Wait()
+	s.expectCalls("getVolumeInformation", 1)
+}
+
+func TestCacheFieldTTLs(t *testing.T) {
+	s := newCachingService(t, map[string]time.Duration{device.FieldAudio: 100 * time.Millisecond})
+
+	expect(t, "volume level", s.get("/:address/volume/level", nil), http.StatusOK)
+	expect(t, "power status", s.get("/:address/power/status", nil), http.StatusOK)
+
+	time.Sleep(200 * time.Millisecond)
+
+	// audio has expired, but power is still cached for the minute everything else is
+	expect(t, "volume level", s.get("/:address/volume/level", nil), http.StatusOK)
+	expect(t, "power status", s.get("/:address/power/status", nil), http.StatusOK)
+
+	s.expectCalls("getVolumeInformation", 2)
+	s.expectCalls("getPowerStatus", 1)
+}
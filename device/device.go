@@ -1,53 +1,238 @@
 package device
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"sync"
+	"time"
 
+	"github.com/byuoitav/sony-control-microservice/device/audit"
+	"github.com/byuoitav/sony-control-microservice/device/auth"
+	"github.com/byuoitav/sony-control-microservice/device/helpers"
+	"github.com/byuoitav/sony-control-microservice/device/notify"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
 
+// Defaults for the http server's timeouts
+const (
+	DefaultReadTimeout     = 30 * time.Second
+	DefaultWriteTimeout    = 2 * time.Minute
+	DefaultIdleTimeout     = 2 * time.Minute
+	DefaultShutdownTimeout = 30 * time.Second
+
+	// DefaultPowerTimeout is how long a TV gets to turn on or off
+	DefaultPowerTimeout = time.Minute
+
+	readHeaderTimeout = 10 * time.Second
+
+	// cancelGrace is how long cancelled requests get to respond before their connections are closed
+	cancelGrace = 5 * time.Second
+)
+
 type DeviceManager struct {
 	Log *zap.Logger
+
+	// QueueDepth is how many actions can wait for each device before new ones are rejected, and
+	// QueueTimeout is how long an action can wait for its turn before it's given up on
+	QueueDepth   int
+	QueueTimeout time.Duration
+
+	// CacheTTL is how long status reads are cached for. CacheTTLs overrides it for individual fields
+	CacheTTL  time.Duration
+	CacheTTLs map[string]time.Duration
+
+	// Audit records every action taken on a device. Nothing is recorded if it's nil
+	Audit *audit.Log
+
+	// ReadTimeout, WriteTimeout and IdleTimeout configure the http server. WriteTimeout has to be long
+	// enough for a TV to change power. ShutdownTimeout is how long in-flight requests get to finish
+	// after a shutdown is requested, before they're cancelled
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration
+
+	// PowerTimeout is how long a TV gets to turn on or off before the request fails with a power_transition_timeout
+	PowerTimeout time.Duration
+
+	// VerifyRetries is how many times a change is made again if reading the device back shows it didn't
+	// take. VerifyBackoff is how long to wait before reading it back again, and doubles for each retry
+	VerifyRetries int
+	VerifyBackoff time.Duration
+
+	// LegacyRoutes keeps the original GET-only endpoints registered alongside /v2
+	LegacyRoutes bool
+
+	eventsOnce sync.Once
+	events     *notify.Hub
+
+	workersMu sync.Mutex
+	workers   map[string]*worker
+
+	cache statusCache
+
+	jobs jobStore
 }
 
 func (d *DeviceManager) GetLogger() *zap.Logger {
 	return d.Log
 }
 
-func (d *DeviceManager) RunHTTPServer(router *gin.Engine, port string) error {
+// hub returns the notification hub, creating it the first time it's needed
+func (d *DeviceManager) hub() *notify.Hub {
+	d.eventsOnce.Do(func() {
+		d.events = notify.NewHub(helpers.Client, d.Log)
+	})
+
+	return d.events
+}
+
+// RegisterRoutes registers every endpoint on router
+func (d *DeviceManager) RegisterRoutes(router *gin.Engine) {
+	router.HandleMethodNotAllowed = true
+	router.NoRoute(noRoute)
+	router.NoMethod(noMethod)
+
+	admin := router.Group("", d.authorize(auth.RoleAdmin))
+	admin.GET("/audit", d.GetAudit)
+	admin.GET("/v2/audit", d.GetAudit)
+
+	read := router.Group("", d.authorize(auth.RoleRead))
+	read.GET("/jobs/:id", d.GetJob)
+	read.GET("/v2/jobs/:id", handle(d, "Failed to get job", func(context *gin.Context, _ string) (Job, error) {
+		return d.getJob(context)
+	}))
+
+	d.registerV2(router.Group("/v2", resolveDevice))
+
+	if d.LegacyRoutes {
+		d.registerLegacy(router.Group("", resolveDevice))
+	}
+}
+
+// RunHTTPServer registers every endpoint on router, then serves it on port until ctx is done. Then it
+// stops accepting requests and waits for the ones in flight (e.g. power transitions), and any running
+// jobs, to finish, cancelling any that are still running after ShutdownTimeout
+func (d *DeviceManager) RunHTTPServer(ctx context.Context, router *gin.Engine, port string) error {
 	d.Log.Info("registering http endpoints")
-	// action endpoints
-	route := router.Group("")
-	route.GET("/:address/power/on", d.PowerOn)
-	route.GET("/:address/power/standby", d.Standby)
-	route.GET("/:address/input/:port", d.SwitchInput)
-	route.GET("/:address/volume/set/:value", d.SetVolume)
-	route.GET("/:address/volume/mute", d.VolumeMute)
-	route.GET("/:address/volume/unmute", d.VolumeUnmute)
-	route.GET("/:address/display/blank", d.BlankDisplay)
-	route.GET("/:address/display/unblank", d.UnblankDisplay)
+	d.RegisterRoutes(router)
 
-	// status endpoints
-	route.GET("/:address/power/status", d.GetPower)
-	route.GET("/:address/input/current", d.GetInput)
-	route.GET("/:address/input/list", d.GetInputList)
-	route.GET("/:address/active/:port", d.GetActiveSignal)
-	route.GET("/:address/volume/level", d.GetVolume)
-	route.GET("/:address/volume/mute/status", d.GetMute)
-	route.GET("/:address/display/status", d.GetBlank)
-	route.GET("/:address/hardware", d.GetHardwareInfo)
+	// every request's context comes from base, so that cancelling it cancels everything in flight
+	base, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	server := &http.Server{
-		Addr:           port,
-		MaxHeaderBytes: 1021 * 10,
+		Addr:              port,
+		Handler:           router,
+		ReadTimeout:       withDefault(d.ReadTimeout, DefaultReadTimeout),
+		ReadHeaderTimeout: readHeaderTimeout,
+		WriteTimeout:      withDefault(d.WriteTimeout, DefaultWriteTimeout),
+		IdleTimeout:       withDefault(d.IdleTimeout, DefaultIdleTimeout),
+		MaxHeaderBytes:    10 << 10,
+		BaseContext: func(net.Listener) context.Context {
+			return base
+		},
+	}
+
+	// event streams never finish on their own, so end them as soon as we start shutting down
+	server.RegisterOnShutdown(func() {
+		d.hub().Close()
+	})
+
+	errs := make(chan error, 1)
+	go func() {
+		d.Log.Info("running http server", zap.String("port", port))
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		d.Log.Error("http server stopped", zap.Error(err))
+		return fmt.Errorf("http server stopped: %w", err)
+	case <-ctx.Done():
+	}
+
+	timeout := withDefault(d.ShutdownTimeout, DefaultShutdownTimeout)
+	d.Log.Info("shutting down http server", zap.Duration("timeout", timeout))
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), timeout)
+	defer cancelShutdown()
+
+	err := server.Shutdown(shutdownCtx)
+
+	// requests that are still running can't start jobs we'd miss waiting for
+	d.jobs.close()
+	if err == nil {
+		err = d.jobs.wait(shutdownCtx)
+	}
+
+	if err != nil {
+		d.Log.Warn("requests still in flight after shutdown timeout, cancelling them", zap.Error(err))
+		cancel()
+		d.jobs.cancelAll()
+
+		graceCtx, cancelGraceCtx := context.WithTimeout(context.Background(), cancelGrace)
+		defer cancelGraceCtx()
+
+		if err := server.Shutdown(graceCtx); err != nil {
+			d.Log.Warn("closing connections of requests that didn't stop", zap.Error(err))
+			server.Close()
+		}
+
+		if err := d.jobs.wait(graceCtx); err != nil {
+			d.Log.Warn("jobs didn't stop after being cancelled", zap.Error(err))
+		}
 	}
 
-	d.Log.Info("running http server", zap.String("port", port))
-	err := router.Run(server.Addr)
+	if err := <-errs; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("http server stopped: %w", err)
+	}
 
-	d.Log.Error("http server stopped", zap.Error(err))
+	d.Log.Info("http server stopped")
+	return nil
+}
+
+func withDefault(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+
+	return d
+}
+
+// registerLegacy registers the original endpoints, which do everything with a GET
+func (d *DeviceManager) registerLegacy(route *gin.RouterGroup) {
+	// action endpoints
+	control := route.Group("", d.recordAction, d.authorize(auth.RoleControl))
+	control.GET("/:address/power/on", d.PowerOn)
+	control.GET("/:address/power/standby", d.Standby)
+	control.GET("/:address/input/:port", d.SwitchInput)
+	control.GET("/:address/volume/set/:value", d.SetVolume)
+	control.GET("/:address/volume/mute", d.VolumeMute)
+	control.GET("/:address/volume/unmute", d.VolumeUnmute)
+	control.GET("/:address/display/blank", d.BlankDisplay)
+	control.GET("/:address/display/unblank", d.UnblankDisplay)
+	control.PUT("/:address/state", d.SetState)
+
+	// status endpoints
+	read := route.Group("", d.authorize(auth.RoleRead))
+	read.GET("/:address/power/status", d.GetPower)
+	read.GET("/:address/input/current", d.GetInput)
+	read.GET("/:address/input/list", d.GetInputList)
+	read.GET("/:address/active/:port", d.GetActiveSignal)
+	read.GET("/:address/volume/level", d.GetVolume)
+	read.GET("/:address/volume/mute/status", d.GetMute)
+	read.GET("/:address/display/status", d.GetBlank)
+	read.GET("/:address/hardware", d.GetHardwareInfo)
+	read.GET("/:address/state", d.GetState)
+	read.GET("/:address/remote/list", d.GetRemoteKeys)
+	read.GET("/:address/events", d.StreamEvents)
 
-	return fmt.Errorf("http server stopped")
+	// admin endpoints
+	admin := route.Group("", d.recordAction, d.authorize(auth.RoleAdmin))
+	admin.GET("/:address/remote/:key", d.SendRemoteKey)
 }
@@ -0,0 +1,562 @@
+package device_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/byuoitav/sony-control-microservice/device"
+	"github.com/byuoitav/sony-control-microservice/device/audit"
+	"github.com/byuoitav/sony-control-microservice/device/auth"
+	"github.com/byuoitav/sony-control-microservice/device/helpers"
+	"github.com/byuoitav/sony-control-microservice/device/inventory"
+	"github.com/byuoitav/sony-control-microservice/simulator"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap/zaptest"
+)
+
+const testPSK = "test-psk"
+
+// testService is the real router, talking to a simulated TV
+type testService struct {
+	t       *testing.T
+	tv      *simulator.TV
+	manager *device.DeviceManager
+	server  *httptest.Server
+
+	// address is the simulated TV's address, as it goes in a route
+	address string
+
+	// apiKey, if it's set, is sent with every request
+	apiKey string
+}
+
+// newTestService starts a simulated TV and a service to control it. configure, if it isn't nil, can
+// change the DeviceManager, or add middleware to the router, before its routes are registered
+func newTestService(t *testing.T, configure func(d *device.DeviceManager, router *gin.Engine)) *testService {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	t.Setenv("SONY_TV_PSK", testPSK)
+
+	tv := simulator.New(testPSK)
+	tvServer := httptest.NewServer(tv)
+	t.Cleanup(tvServer.Close)
+
+	manager := &device.DeviceManager{
+		Log:          zaptest.NewLogger(t),
+		CacheTTL:     -1,
+		LegacyRoutes: true,
+	}
+
+	router := gin.New()
+	router.Use(device.RequestID)
+
+	if configure != nil {
+		configure(manager, router)
+	}
+
+	manager.RegisterRoutes(router)
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	return &testService{
+		t:       t,
+		tv:      tv,
+		manager: manager,
+		server:  server,
+		address: strings.TrimPrefix(tvServer.URL, "http://"),
+	}
+}
+
+// do sends a request for path (with the TV's address in place of :address) and decodes the response into v,
+// returning its status code
+func (s *testService) do(method, path, body string, v interface{}) int {
+	s.t.Helper()
+
+	req, err := http.NewRequest(method, s.server.URL+strings.ReplaceAll(path, ":address", s.address), strings.NewReader(body))
+	if err != nil {
+		s.t.Fatalf("unable to build request: %s", err)
+	}
+
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if s.apiKey != "" {
+		req.Header.Set(auth.APIKeyHeader, s.apiKey)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		s.t.Fatalf("%s %s failed: %s", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		s.t.Fatalf("unable to read response to %s %s: %s", method, path, err)
+	}
+
+	if v != nil {
+		if err := json.Unmarshal(buf, v); err != nil {
+			s.t.Fatalf("unable to decode response to %s %s (%s): %s", method, path, buf, err)
+		}
+	}
+
+	return resp.StatusCode
+}
+
+// as returns a copy of s that sends its requests with apiKey
+func (s *testService) as(apiKey string) *testService {
+	c := *s
+	c.apiKey = apiKey
+
+	return &c
+}
+
+// get is do for a GET with no body
+func (s *testService) get(path string, v interface{}) int {
+	s.t.Helper()
+	return s.do(http.MethodGet, path, "", v)
+}
+
+// waitForJob polls job until it isn't running any more, returning how it finished
+func (s *testService) waitForJob(job device.Job) device.Job {
+	s.t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); job.Status == device.JobRunning; time.Sleep(50 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			s.t.Fatal("job is still running after 5s")
+		}
+
+		expect(s.t, "get job", s.get("/jobs/"+job.ID, &job), http.StatusOK)
+	}
+
+	return job
+}
+
+// useInventory loads devices as the inventory until the test finishes
+func useInventory(t *testing.T, devices map[string]inventory.Device) {
+	t.Helper()
+
+	b, err := json.Marshal(map[string]interface{}{"devices": devices})
+	if err != nil {
+		t.Fatalf("unable to encode inventory: %s", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatalf("unable to write inventory: %s", err)
+	}
+
+	inv, err := inventory.Load(path)
+	if err != nil {
+		t.Fatalf("unable to load inventory: %s", err)
+	}
+
+	before := helpers.Inventory
+	helpers.Inventory = inv
+	t.Cleanup(func() { helpers.Inventory = before })
+}
+
+// expect fails the test if code isn't want
+func expect(t *testing.T, what string, code, want int) {
+	t.Helper()
+
+	if code != want {
+		t.Fatalf("%s: got status %d, want %d", what, code, want)
+	}
+}
+
+// expectError fails the test unless resp is an error with the given code
+func expectError(t *testing.T, what string, resp device.Response, code string) {
+	t.Helper()
+
+	if resp.Error == nil {
+		t.Fatalf("%s: got no error, want %s", what, code)
+	}
+
+	if resp.Error.Code != code {
+		t.Fatalf("%s: got error %s (%s), want %s", what, resp.Error.Code, resp.Error.Message, code)
+	}
+
+	if resp.Error.RequestID == "" {
+		t.Errorf("%s: error has no request id", what)
+	}
+}
+
+func TestPower(t *testing.T) {
+	s := newTestService(t, nil)
+
+	var power struct{ Power string }
+	expect(t, "standby", s.get("/:address/power/standby", &power), http.StatusOK)
+	if power.Power != "standby" || s.tv.State().Power {
+		t.Fatalf("got power %q (tv on: %v) after standby", power.Power, s.tv.State().Power)
+	}
+
+	expect(t, "power status", s.get("/:address/power/status", &power), http.StatusOK)
+	if power.Power != "standby" {
+		t.Fatalf("got power status %q, want standby", power.Power)
+	}
+
+	expect(t, "power on", s.get("/:address/power/on", &power), http.StatusOK)
+	if power.Power != "on" || !s.tv.State().Power {
+		t.Fatalf("got power %q (tv on: %v) after power on", power.Power, s.tv.State().Power)
+	}
+
+	var resp device.Response
+	expect(t, "v2 standby", s.do(http.MethodPut, "/v2/:address/power", `{"power": "standby"}`, &resp), http.StatusOK)
+	if s.tv.State().Power {
+		t.Fatal("tv is still on after v2 standby")
+	}
+}
+
+func TestInput(t *testing.T) {
+	s := newTestService(t, nil)
+
+	var input struct {
+		Input    string
+		Verified bool
+	}
+	expect(t, "switch input", s.get("/:address/input/hdmi!3", &input), http.StatusOK)
+	if input.Input != "hdmi!3" || !input.Verified {
+		t.Fatalf("got input %q (verified %v), want verified hdmi!3", input.Input, input.Verified)
+	}
+
+	if got := s.tv.State().Input; got != "extInput:hdmi?port=3" {
+		t.Fatalf("tv is showing %q, want hdmi 3", got)
+	}
+
+	expect(t, "current input", s.get("/:address/input/current", &input), http.StatusOK)
+	if input.Input != "hdmi!3" {
+		t.Fatalf("got current input %q, want hdmi!3", input.Input)
+	}
+
+	var list []struct{ Port string }
+	expect(t, "input list", s.get("/:address/input/list", &list), http.StatusOK)
+	if len(list) != 4 {
+		t.Fatalf("got %d inputs, want 4", len(list))
+	}
+}
+
+func TestActiveSignal(t *testing.T) {
+	s := newTestService(t, nil)
+
+	// hdmi 1 and 3 both have signal, so the answer can't depend on which one the tv lists last
+	inputs := s.tv.Inputs()
+	inputs[2].Signal = true
+	s.tv.SetInputs(inputs)
+
+	for port, want := range map[string]bool{"hdmi!1": true, "hdmi!2": false, "hdmi!3": true, "hdmi!4": false} {
+		var active struct{ Active bool }
+		expect(t, "active "+port, s.get("/:address/active/"+port, &active), http.StatusOK)
+
+		if active.Active != want {
+			t.Errorf("got active %v for %s, want %v", active.Active, port, want)
+		}
+	}
+}
+
+func TestVolume(t *testing.T) {
+	s := newTestService(t, nil)
+
+	var volume struct {
+		Volume   int
+		Verified bool
+	}
+	expect(t, "set volume", s.get("/:address/volume/set/35", &volume), http.StatusOK)
+	if volume.Volume != 35 || !volume.Verified {
+		t.Fatalf("got volume %d (verified %v), want verified 35", volume.Volume, volume.Verified)
+	}
+
+	if got := s.tv.State().Volume; got != 35 {
+		t.Fatalf("tv volume is %d, want 35", got)
+	}
+
+	expect(t, "volume level", s.get("/:address/volume/level", &volume), http.StatusOK)
+	if volume.Volume != 35 {
+		t.Fatalf("got volume level %d, want 35", volume.Volume)
+	}
+
+	var resp struct {
+		Data struct {
+			Volume   int
+			Verified bool
+		}
+	}
+	expect(t, "v2 volume", s.do(http.MethodPut, "/v2/:address/volume", `{"volume": 12}`, &resp), http.StatusOK)
+	if got := s.tv.State().Volume; got != 12 || !resp.Data.Verified {
+		t.Fatalf("tv volume is %d (verified %v) after v2 volume, want verified 12", got, resp.Data.Verified)
+	}
+}
+
+func TestMute(t *testing.T) {
+	s := newTestService(t, nil)
+
+	var mute struct {
+		Muted    bool
+		Verified bool
+	}
+	expect(t, "mute", s.get("/:address/volume/mute", &mute), http.StatusOK)
+	if !mute.Muted || !mute.Verified || !s.tv.State().Muted {
+		t.Fatalf("got muted %v (verified %v, tv muted %v) after mute", mute.Muted, mute.Verified, s.tv.State().Muted)
+	}
+
+	expect(t, "mute status", s.get("/:address/volume/mute/status", &mute), http.StatusOK)
+	if !mute.Muted {
+		t.Fatal("mute status isn't muted after mute")
+	}
+
+	expect(t, "unmute", s.get("/:address/volume/unmute", &mute), http.StatusOK)
+	if mute.Muted || !mute.Verified || s.tv.State().Muted {
+		t.Fatalf("got muted %v (verified %v, tv muted %v) after unmute", mute.Muted, mute.Verified, s.tv.State().Muted)
+	}
+}
+
+func TestBlanking(t *testing.T) {
+	s := newTestService(t, nil)
+
+	var blanked struct {
+		Blanked  bool
+		Verified bool
+	}
+	expect(t, "blank", s.get("/:address/display/blank", &blanked), http.StatusOK)
+	if !blanked.Blanked || !blanked.Verified {
+		t.Fatalf("got blanked %v (verified %v) after blank", blanked.Blanked, blanked.Verified)
+	}
+
+	if got := s.tv.State().PowerSavingMode; got != "pictureOff" {
+		t.Fatalf("tv power saving mode is %q after blank, want pictureOff", got)
+	}
+
+	expect(t, "blank status", s.get("/:address/display/status", &blanked), http.StatusOK)
+	if !blanked.Blanked {
+		t.Fatal("display status isn't blanked after blank")
+	}
+
+	expect(t, "unblank", s.get("/:address/display/unblank", &blanked), http.StatusOK)
+	if got := s.tv.State().PowerSavingMode; blanked.Blanked || got == "pictureOff" {
+		t.Fatalf("got blanked %v (power saving mode %q) after unblank", blanked.Blanked, got)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	s := newTestService(t, nil)
+
+	var resp device.Response
+	expect(t, "volume out of range", s.get("/:address/volume/set/101", &resp), http.StatusBadRequest)
+	expectError(t, "volume out of range", resp, device.ErrCodeInvalidRequest)
+	if resp.Error.Address != s.address {
+		t.Errorf("got error address %q, want %q", resp.Error.Address, s.address)
+	}
+
+	resp = device.Response{}
+	expect(t, "bad v2 body", s.do(http.MethodPut, "/v2/:address/volume", `{"volume": "loud"}`, &resp), http.StatusBadRequest)
+	expectError(t, "bad v2 body", resp, device.ErrCodeInvalidRequest)
+
+	resp = device.Response{}
+	expect(t, "unknown route", s.get("/:address/nothing/here/at/all", &resp), http.StatusNotFound)
+	expectError(t, "unknown route", resp, device.ErrCodeNotFound)
+
+	resp = device.Response{}
+	expect(t, "wrong method", s.do(http.MethodPost, "/v2/:address/volume", `{"volume": 10}`, &resp), http.StatusMethodNotAllowed)
+	expectError(t, "wrong method", resp, device.ErrCodeMethodNotAllowed)
+
+	resp = device.Response{}
+	huge := `{"volume": 10, "padding": "` + strings.Repeat("x", 100<<10) + `"}`
+	expect(t, "huge body", s.do(http.MethodPut, "/v2/:address/volume", huge, &resp), http.StatusRequestEntityTooLarge)
+	expectError(t, "huge body", resp, device.ErrCodeBodyTooLarge)
+
+	s.tv.SetPSK("another-psk")
+	resp = device.Response{}
+	expect(t, "wrong psk", s.get("/:address/volume/set/10", &resp), http.StatusBadGateway)
+	expectError(t, "wrong psk", resp, device.ErrCodeBadPSK)
+}
+
+func TestRemoteKeyPolicy(t *testing.T) {
+	s := newTestService(t, nil)
+	useInventory(t, map[string]inventory.Device{
+		"ITB-1101-D1": {Address: s.address, Policy: inventory.Policy{DisableStandby: true}},
+	})
+
+	for _, key := range []string{"PowerOff", "tvpower", "Sleep"} {
+		var resp device.Response
+		expect(t, "remote "+key, s.get("/:address/remote/"+key, &resp), http.StatusForbidden)
+		expectError(t, "remote "+key, resp, device.ErrCodePolicyViolation)
+	}
+
+	if !s.tv.State().Power {
+		t.Fatal("a remote key put the tv in standby, even though its policy disables standby")
+	}
+
+	expect(t, "remote home", s.get("/:address/remote/Home", nil), http.StatusOK)
+}
+
+func TestInventoryLookup(t *testing.T) {
+	s := newTestService(t, nil)
+	useInventory(t, map[string]inventory.Device{
+		"ITB-1101-D1": {Address: s.address},
+	})
+
+	// ids are case insensitive, on both the legacy and v2 routes
+	for _, id := range []string{"ITB-1101-D1", "itb-1101-d1", "Itb-1101-D1"} {
+		var volume struct{ Volume int }
+		expect(t, "volume level of "+id, s.get("/"+id+"/volume/level", &volume), http.StatusOK)
+		if volume.Volume != 20 {
+			t.Fatalf("got volume %d from %s, want 20", volume.Volume, id)
+		}
+
+		expect(t, "v2 power of "+id, s.get("/v2/"+id+"/power", nil), http.StatusOK)
+	}
+
+	expect(t, "set volume by id", s.get("/itb-1101-d1/volume/set/30", nil), http.StatusOK)
+	if got := s.tv.State().Volume; got != 30 {
+		t.Fatalf("tv volume is %d after setting it by id, want 30", got)
+	}
+}
+
+func TestInputAliases(t *testing.T) {
+	s := newTestService(t, nil)
+	useInventory(t, map[string]inventory.Device{
+		"ITB-1101-D1": {Address: s.address, Inputs: map[string]string{"Desktop": "hdmi!1", "Laptop": "hdmi!2"}},
+	})
+
+	for _, path := range []string{"/:address/input/laptop", "/itb-1101-d1/input/LAPTOP"} {
+		s.tv.SetState(simulator.State{Power: true, Input: "extInput:hdmi?port=1", Volume: 20})
+
+		var input device.VerifiedInput
+		expect(t, path, s.get(path, &input), http.StatusOK)
+		if input.Input.Input != "hdmi!2" || input.Alias != "Laptop" {
+			t.Fatalf("%s: got input %q (alias %q), want hdmi!2 (Laptop)", path, input.Input.Input, input.Alias)
+		}
+
+		if got := s.tv.State().Input; got != "extInput:hdmi?port=2" {
+			t.Fatalf("%s: tv is showing %q, want hdmi 2", path, got)
+		}
+	}
+
+	// hdmi 1 has signal, hdmi 2 doesn't
+	signals := map[string]device.ActiveSignal{
+		"/:address/active/desktop":   {Active: true, Input: "hdmi!1", Alias: "Desktop"},
+		"/itb-1101-d1/active/Laptop": {Active: false, Input: "hdmi!2", Alias: "Laptop"},
+	}
+
+	for path, want := range signals {
+		var active device.ActiveSignal
+		expect(t, path, s.get(path, &active), http.StatusOK)
+		if active != want {
+			t.Fatalf("%s: got %+v, want %+v", path, active, want)
+		}
+	}
+
+	code, outcomes, _ := s.putState(`{"input": "desktop"}`)
+	expect(t, "put state", code, http.StatusOK)
+	expectOutcome(t, outcomes, "input", device.OutcomeChanged)
+
+	if got := s.tv.State().Input; got != "extInput:hdmi?port=1" {
+		t.Fatalf("tv is showing %q after putting the desktop alias, want hdmi 1", got)
+	}
+
+	expect(t, "put state by id", s.do(http.MethodPut, "/ITB-1101-D1/state", `{"input": "laptop"}`, nil), http.StatusOK)
+	if got := s.tv.State().Input; got != "extInput:hdmi?port=2" {
+		t.Fatalf("tv is showing %q after putting the laptop alias by id, want hdmi 2", got)
+	}
+}
+
+func TestInventoryPolicy(t *testing.T) {
+	s := newTestService(t, nil)
+	useInventory(t, map[string]inventory.Device{
+		"ITB-1101-D1": {Address: s.address, Policy: inventory.Policy{MaxVolume: 50, DisableStandby: true}},
+	})
+
+	// the policy applies whether the tv is named by its id or its address
+	for _, name := range []string{":address", "itb-1101-d1"} {
+		denied := []struct {
+			method, path, body string
+		}{
+			{http.MethodGet, "/" + name + "/volume/set/60", ""},
+			{http.MethodGet, "/" + name + "/power/standby", ""},
+			{http.MethodPut, "/" + name + "/state", `{"volume": 80}`},
+			{http.MethodPut, "/v2/" + name + "/volume", `{"volume": 60}`},
+			{http.MethodPut, "/v2/" + name + "/power", `{"power": "standby"}`},
+		}
+
+		for _, tt := range denied {
+			var resp device.Response
+			what := tt.method + " " + tt.path
+			expect(t, what, s.do(tt.method, tt.path, tt.body, &resp), http.StatusForbidden)
+			expectError(t, what, resp, device.ErrCodePolicyViolation)
+		}
+
+		if got := s.tv.State(); !got.Power || got.Volume != 20 {
+			t.Fatalf("tv is %+v after changes its policy forbids", got)
+		}
+
+		expect(t, "volume at the limit", s.get("/"+name+"/volume/set/50", nil), http.StatusOK)
+		expect(t, "volume under the limit", s.get("/"+name+"/volume/set/20", nil), http.StatusOK)
+	}
+}
+
+func TestConcurrentReads(t *testing.T) {
+	s := newTestService(t, nil)
+
+	paths := []string{"/:address/volume/level", "/:address/state", "/:address/power/status", "/v2/:address/mute"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		for _, path := range paths {
+			wg.Add(1)
+			go func(path string) {
+				defer wg.Done()
+
+				if code := s.get(path, nil); code != http.StatusOK {
+					t.Errorf("%s: got status %d, want %d", path, code, http.StatusOK)
+				}
+			}(path)
+		}
+	}
+
+	wg.Wait()
+}
+
+func TestAsyncPower(t *testing.T) {
+	log, err := audit.Open(filepath.Join(t.TempDir(), "audit.log"), 0, 0)
+	if err != nil {
+		t.Fatalf("unable to open audit log: %s", err)
+	}
+	defer log.Close()
+
+	s := newTestService(t, func(d *device.DeviceManager, _ *gin.Engine) {
+		d.Audit = log
+	})
+
+	var job device.Job
+	expect(t, "async standby", s.get("/:address/power/standby?async=true", &job), http.StatusAccepted)
+
+	job = s.waitForJob(job)
+
+	if job.Status != device.JobSucceeded || s.tv.State().Power {
+		t.Fatalf("job %s (tv on: %v), want it to have succeeded", job.Status, s.tv.State().Power)
+	}
+
+	records, err := log.Query(audit.Query{})
+	if err != nil {
+		t.Fatalf("unable to query audit log: %s", err)
+	}
+
+	for _, r := range records {
+		if r.Action == "job power" && r.Params["job"] == job.ID && r.Params["status"] == device.JobSucceeded {
+			return
+		}
+	}
+
+	t.Fatalf("no audit record of how the job finished in %+v", records)
+}
@@ -0,0 +1,218 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/byuoitav/sony-control-microservice/device/allowlist"
+	"github.com/byuoitav/sony-control-microservice/device/auth"
+	"github.com/byuoitav/sony-control-microservice/device/helpers"
+	"github.com/byuoitav/sony-control-microservice/device/scalar"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Error codes returned in the body of failed requests
+const (
+	ErrCodeInternal         = "internal"
+	ErrCodeUnreachable      = "unreachable"
+	ErrCodeTimeout          = "timeout"
+	ErrCodeDisplayOff       = "display_off"
+	ErrCodeIllegalState     = "illegal_state"
+	ErrCodeIllegalArgument  = "illegal_argument"
+	ErrCodeBadPSK           = "bad_psk"
+	ErrCodeUnsupported      = "unsupported"
+	ErrCodeUnknownRemoteKey = "unknown_remote_key"
+	ErrCodeDeviceError      = "device_error"
+	ErrCodeQueueFull        = "queue_full"
+	ErrCodeQueueTimeout     = "queue_timeout"
+	ErrCodeInvalidRequest   = "invalid_request"
+	ErrCodeNotFound         = "not_found"
+	ErrCodeMethodNotAllowed = "method_not_allowed"
+	ErrCodePolicyViolation  = "policy_violation"
+	ErrCodeNotAllowed       = "address_not_allowed"
+	ErrCodeUnauthorized     = "unauthorized"
+	ErrCodeForbidden        = "forbidden"
+	ErrCodeCanceled         = "canceled"
+	ErrCodeBodyTooLarge     = "body_too_large"
+	ErrCodeShuttingDown     = "shutting_down"
+
+	ErrCodePowerTransitionTimeout = "power_transition_timeout"
+)
+
+// ErrInvalidRequest is wrapped by errors caused by the client's request, rather than the device
+var ErrInvalidRequest = errors.New("invalid request")
+
+// ErrNotFound is wrapped by errors for things that don't exist, e.g. an unknown job
+var ErrNotFound = errors.New("not found")
+
+func invalidRequest(format string, a ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidRequest, fmt.Sprintf(format, a...))
+}
+
+// Response is the envelope every error is returned in, and every v2 response. Error is set if the
+// request failed, and Data is set if it succeeded (or, for PUT /state, partly succeeded)
+type Response struct {
+	Data  interface{}    `json:"data,omitempty"`
+	Error *ErrorResponse `json:"error,omitempty"`
+}
+
+// ErrorResponse describes why a request failed
+type ErrorResponse struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Address   string `json:"address,omitempty"`
+	SonyCode  int    `json:"sonyCode,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+
+	// Details has more about some errors, e.g. how far a power transition got before it timed out
+	Details interface{} `json:"details,omitempty"`
+}
+
+// PowerTransitionDetails are the Details of a power_transition_timeout error
+type PowerTransitionDetails struct {
+	Target    string `json:"target"`
+	Last      string `json:"last,omitempty"`
+	ElapsedMS int64  `json:"elapsedMs"`
+}
+
+// classifyError maps err to the http status and body we should return for it
+func classifyError(err error) (int, ErrorResponse) {
+	resp := ErrorResponse{
+		Code:    ErrCodeInternal,
+		Message: err.Error(),
+	}
+
+	var sonyErr *scalar.SonyError
+	var unreachable *scalar.UnreachableError
+	var transition *helpers.PowerTransitionError
+	var tooLarge *http.MaxBytesError
+
+	switch {
+	case errors.As(err, &transition) && errors.Is(err, context.DeadlineExceeded):
+		resp.Code = ErrCodePowerTransitionTimeout
+		resp.Details = PowerTransitionDetails{
+			Target:    transition.Target,
+			Last:      transition.Last,
+			ElapsedMS: transition.Elapsed.Milliseconds(),
+		}
+
+		return http.StatusGatewayTimeout, resp
+	case errors.As(err, &sonyErr):
+		resp.SonyCode = sonyErr.Code
+
+		switch sonyErr.Code {
+		case scalar.ErrDisplayOff:
+			resp.Code = ErrCodeDisplayOff
+			return http.StatusConflict, resp
+		case scalar.ErrIllegalState:
+			resp.Code = ErrCodeIllegalState
+			return http.StatusConflict, resp
+		case scalar.ErrIllegalArgument:
+			resp.Code = ErrCodeIllegalArgument
+			return http.StatusBadRequest, resp
+		case scalar.ErrUnauthorized, scalar.ErrForbidden:
+			resp.Code = ErrCodeBadPSK
+			return http.StatusBadGateway, resp
+		case scalar.ErrNoSuchMethod, scalar.ErrUnsupportedVersion, scalar.ErrUnsupportedOperation:
+			resp.Code = ErrCodeUnsupported
+			return http.StatusNotImplemented, resp
+		case scalar.ErrTimeout:
+			resp.Code = ErrCodeTimeout
+			return http.StatusGatewayTimeout, resp
+		default:
+			resp.Code = ErrCodeDeviceError
+			return http.StatusBadGateway, resp
+		}
+	case errors.Is(err, allowlist.ErrNotAllowed):
+		resp.Code = ErrCodeNotAllowed
+		return http.StatusForbidden, resp
+	case errors.As(err, &unreachable):
+		resp.Code = ErrCodeUnreachable
+		return http.StatusGatewayTimeout, resp
+	case errors.Is(err, context.DeadlineExceeded):
+		resp.Code = ErrCodeTimeout
+		return http.StatusGatewayTimeout, resp
+	case errors.Is(err, context.Canceled):
+		resp.Code = ErrCodeCanceled
+		return http.StatusServiceUnavailable, resp
+	case errors.As(err, &tooLarge):
+		resp.Code = ErrCodeBodyTooLarge
+		return http.StatusRequestEntityTooLarge, resp
+	case errors.Is(err, ErrInvalidRequest):
+		resp.Code = ErrCodeInvalidRequest
+		return http.StatusBadRequest, resp
+	case errors.Is(err, ErrPolicy):
+		resp.Code = ErrCodePolicyViolation
+		return http.StatusForbidden, resp
+	case errors.Is(err, auth.ErrNoCredentials), errors.Is(err, auth.ErrInvalidCredentials):
+		resp.Code = ErrCodeUnauthorized
+		return http.StatusUnauthorized, resp
+	case errors.Is(err, auth.ErrForbidden):
+		resp.Code = ErrCodeForbidden
+		return http.StatusForbidden, resp
+	case errors.Is(err, ErrShuttingDown):
+		resp.Code = ErrCodeShuttingDown
+		return http.StatusServiceUnavailable, resp
+	case errors.Is(err, ErrQueueFull):
+		resp.Code = ErrCodeQueueFull
+		return http.StatusServiceUnavailable, resp
+	case errors.Is(err, ErrQueueTimeout):
+		resp.Code = ErrCodeQueueTimeout
+		return http.StatusServiceUnavailable, resp
+	case errors.Is(err, ErrNotFound):
+		resp.Code = ErrCodeNotFound
+		return http.StatusNotFound, resp
+	case errors.Is(err, helpers.ErrUnknownRemoteKey):
+		resp.Code = ErrCodeUnknownRemoteKey
+		return http.StatusNotFound, resp
+	}
+
+	return http.StatusInternalServerError, resp
+}
+
+// errorResponse is classifyError for an error that happened while handling a request
+func errorResponse(context *gin.Context, err error) (int, ErrorResponse) {
+	code, resp := classifyError(err)
+	resp.Address = context.Param("address")
+	resp.RequestID = requestID(context)
+
+	return code, resp
+}
+
+// respondError logs err and writes it to the client with the matching status code
+func (d *DeviceManager) respondError(context *gin.Context, msg string, err error) {
+	code, resp := errorResponse(context, err)
+
+	d.Log.Error(msg, zap.String("address", resp.Address), zap.String("code", resp.Code), zap.Int("sonyCode", resp.SonyCode), zap.String("requestID", resp.RequestID), zap.Error(err))
+	writeError(context, code, resp, nil)
+}
+
+const errorKey = "error"
+
+// writeError writes resp (and data, if the request partly succeeded) to the client, and keeps resp on
+// the request for the audit log
+func writeError(context *gin.Context, code int, resp ErrorResponse, data interface{}) {
+	context.Set(errorKey, resp)
+	context.AbortWithStatusJSON(code, Response{Data: data, Error: &resp})
+}
+
+// noRoute and noMethod answer requests for endpoints that don't exist in the same shape as every other error
+
+func noRoute(context *gin.Context) {
+	context.JSON(http.StatusNotFound, Response{Error: &ErrorResponse{
+		Code:      ErrCodeNotFound,
+		Message:   "no such endpoint: " + context.Request.URL.Path,
+		RequestID: requestID(context),
+	}})
+}
+
+func noMethod(context *gin.Context) {
+	context.JSON(http.StatusMethodNotAllowed, Response{Error: &ErrorResponse{
+		Code:      ErrCodeMethodNotAllowed,
+		Message:   context.Request.Method + " isn't allowed on " + context.Request.URL.Path,
+		RequestID: requestID(context),
+	}})
+}
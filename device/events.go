@@ -0,0 +1,60 @@
+package device
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// keepAliveInterval is how often we write a comment to idle event streams so that
+// proxies don't close them
+const keepAliveInterval = 15 * time.Second
+
+// StreamEvents streams the TV's state changes to the client as server-sent events
+func (d *DeviceManager) StreamEvents(context *gin.Context) {
+	address := context.Param("address")
+	d.Log.Debug("Streaming events", zap.String("address", address))
+
+	events, unsubscribe := d.hub().Subscribe(address)
+	defer unsubscribe()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	// the server's write timeout would otherwise cut the stream off, so push the deadline back
+	// before every write instead
+	writeTimeout := withDefault(d.WriteTimeout, DefaultWriteTimeout)
+	controller := http.NewResponseController(context.Writer)
+	extend := func() {
+		if err := controller.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+			d.Log.Debug("unable to extend event stream's write deadline", zap.Error(err))
+		}
+	}
+
+	context.Header("Cache-Control", "no-cache")
+	context.Header("X-Accel-Buffering", "no")
+
+	context.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+
+			extend()
+			context.SSEvent(event.Type, event)
+			return true
+		case <-keepAlive.C:
+			extend()
+			_, err := w.Write([]byte(": keep-alive\n\n"))
+			return err == nil
+		case <-context.Request.Context().Done():
+			return false
+		}
+	})
+
+	d.Log.Debug("Event stream closed", zap.String("address", address))
+}
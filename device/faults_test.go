@@ -0,0 +1,128 @@
+package device_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/byuoitav/sony-control-microservice/device"
+	"github.com/byuoitav/sony-control-microservice/simulator"
+	"github.com/gin-gonic/gin"
+)
+
+func TestSlowPowerTransition(t *testing.T) {
+	s := newTestService(t, nil)
+
+	state := s.tv.State()
+	state.Power = false
+	s.tv.SetState(state)
+	s.tv.SetFaults(simulator.Faults{PowerOnDelay: simulator.Duration(time.Second)})
+
+	var power struct{ Power string }
+	expect(t, "power on", s.get("/:address/power/on", &power), http.StatusOK)
+	if power.Power != "on" || !s.tv.State().Power {
+		t.Fatalf("got power %q (tv on: %v) after a slow power on", power.Power, s.tv.State().Power)
+	}
+}
+
+func TestPowerTransitionTimeout(t *testing.T) {
+	s := newTestService(t, func(d *device.DeviceManager, _ *gin.Engine) {
+		d.PowerTimeout = 600 * time.Millisecond
+	})
+
+	state := s.tv.State()
+	state.Power = false
+	s.tv.SetState(state)
+	s.tv.SetFaults(simulator.Faults{PowerOnDelay: simulator.Duration(time.Minute)})
+
+	var resp struct {
+		Error struct {
+			device.ErrorResponse
+			Details device.PowerTransitionDetails `json:"details"`
+		}
+	}
+	expect(t, "power on", s.get("/:address/power/on", &resp), http.StatusGatewayTimeout)
+
+	if resp.Error.Code != device.ErrCodePowerTransitionTimeout {
+		t.Fatalf("got error %s (%s), want %s", resp.Error.Code, resp.Error.Message, device.ErrCodePowerTransitionTimeout)
+	}
+
+	details := resp.Error.Details
+	if details.Target != "on" || details.Last != "standby" || details.ElapsedMS < 600 {
+		t.Fatalf("got details %+v, want target on, last standby and at least 600ms elapsed", details)
+	}
+}
+
+func TestVerifyRetries(t *testing.T) {
+	s := newTestService(t, func(d *device.DeviceManager, _ *gin.Engine) {
+		d.VerifyRetries = 2
+		d.VerifyBackoff = time.Millisecond
+	})
+
+	// the first two reads after muting are stale, so it takes a retry to see the change
+	s.tv.SetFaults(simulator.Faults{StaleMuteReads: 2})
+
+	var mute struct {
+		Muted    bool
+		Verified bool
+	}
+	expect(t, "mute", s.get("/:address/volume/mute", &mute), http.StatusOK)
+	if !mute.Muted || !mute.Verified {
+		t.Fatalf("got muted %v (verified %v) after mute, want a verified mute", mute.Muted, mute.Verified)
+	}
+}
+
+func TestVerifyGivesUp(t *testing.T) {
+	s := newTestService(t, func(d *device.DeviceManager, _ *gin.Engine) {
+		d.VerifyRetries = 2
+		d.VerifyBackoff = time.Millisecond
+	})
+
+	s.tv.SetFaults(simulator.Faults{StaleMuteReads: 100})
+
+	var mute struct {
+		Muted    bool
+		Verified bool
+	}
+	expect(t, "legacy mute", s.get("/:address/volume/mute", &mute), http.StatusOK)
+	if !mute.Muted || mute.Verified {
+		t.Fatalf("got muted %v (verified %v) after legacy mute, want an unverified mute", mute.Muted, mute.Verified)
+	}
+
+	var v2 struct {
+		Data struct {
+			Muted    bool
+			Verified bool
+		}
+	}
+	expect(t, "v2 unmute", s.do(http.MethodPut, "/v2/:address/mute", `{"muted": false}`, &v2), http.StatusOK)
+	if v2.Data.Verified {
+		t.Fatal("v2 unmute was verified, even though the tv never reported it")
+	}
+}
+
+func TestDisplayOff(t *testing.T) {
+	s := newTestService(t, nil)
+
+	state := s.tv.State()
+	state.Power = false
+	s.tv.SetState(state)
+	s.tv.SetFaults(simulator.Faults{DisplayOffErrors: true})
+
+	var resp device.Response
+	expect(t, "volume level", s.get("/:address/volume/level", &resp), http.StatusConflict)
+	expectError(t, "volume level", resp, device.ErrCodeDisplayOff)
+
+	if resp.Error.SonyCode != 40005 {
+		t.Fatalf("got sony code %d, want 40005", resp.Error.SonyCode)
+	}
+}
+
+func TestDroppedConnections(t *testing.T) {
+	s := newTestService(t, nil)
+	s.tv.SetFaults(simulator.Faults{DropRate: 1})
+
+	var resp device.Response
+	expect(t, "power status", s.get("/:address/power/status", &resp), http.StatusGatewayTimeout)
+	expectError(t, "power status", resp, device.ErrCodeUnreachable)
+}
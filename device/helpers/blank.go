@@ -1,60 +1,40 @@
 package helpers
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 
 	"github.com/byuoitav/common/status"
+	"github.com/byuoitav/sony-control-microservice/device/scalar"
 	"go.uber.org/zap"
 )
 
-type DeviceManagerInterface interface {
-	GetLogger() *zap.Logger
-}
-
-type SonyBaseResult struct {
-	ID     int                 `json:"id"`
-	Result []map[string]string `json:"result"`
-	Error  []interface{}       `json:"error"`
-}
-
-func GetBlanked(address string, d DeviceManagerInterface) (status.Blanked, error) {
+func GetBlanked(ctx context.Context, address string, d DeviceManagerInterface) (status.Blanked, error) {
 	var blanked status.Blanked
 
-	payload := SonyTVRequest{
-		Params:  []map[string]interface{}{},
-		Method:  "getPowerSavingMode",
-		Version: "1.0",
-		ID:      1,
-	}
-	d.GetLogger().Info(fmt.Sprintf("%v", payload), zap.Any("payload", payload))
+	ctx, cancel := context.WithTimeout(ctx, readTimeout)
+	defer cancel()
 
-	resp, err := PostHTTP(address, payload, "system")
+	mode, err := scalar.GetPowerSavingMode.Call(ctx, Client, address)
 	if err != nil {
 		d.GetLogger().Error(fmt.Sprintf("ERROR: %v", err.Error()), zap.Error(err))
 		return blanked, err
 	}
 
-	re := SonyBaseResult{}
-	err = json.Unmarshal(resp, &re)
-	if err != nil {
-		d.GetLogger().Error(fmt.Sprintf("Failed to unmarshal resposne from tv: %v", err.Error()), zap.Error(err))
-		return blanked, fmt.Errorf("failed to unmarshal response from tv: %s", err)
-	}
+	blanked.Blanked = mode.Mode == "pictureOff"
+	return blanked, nil
+}
 
-	// make sure there is a result
-	if len(re.Result) == 0 {
-		d.GetLogger().Error(fmt.Sprintf("No result in response from tv: %v", re.Error), zap.Any("error", re.Error))
-		return blanked, fmt.Errorf("error response from tv: %s", re.Error)
+// SetBlanked turns the TV's picture off (or back on) using its power saving mode
+func SetBlanked(ctx context.Context, address string, blanked bool) error {
+	mode := scalar.PowerSavingMode{Mode: "off"}
+	if blanked {
+		mode.Mode = "pictureOff"
 	}
 
-	if val, ok := re.Result[0]["mode"]; ok {
-		if val == "pictureOff" {
-			blanked.Blanked = true
-		} else {
-			blanked.Blanked = false
-		}
-	}
+	ctx, cancel := context.WithTimeout(ctx, writeTimeout)
+	defer cancel()
 
-	return blanked, nil
+	_, err := scalar.SetPowerSavingMode.Call(ctx, Client, address, mode)
+	return err
 }
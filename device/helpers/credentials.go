@@ -0,0 +1,299 @@
+package helpers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// CredentialStore holds the pre-shared keys for our TVs, keyed by address or hostname.
+//
+// The store is loaded from either a JSON file:
+//
+//	{"default": "key", "devices": {"10.5.34.12": "key2", "itb-1101-d1.byu.edu": "key3"}}
+//
+// or a directory (e.g. a mounted secret) where each file is named after an address or
+// hostname and contains that device's key, and a file named "default" holds the default key.
+// If no default is configured, the SONY_TV_PSK environment variable is used.
+type CredentialStore struct {
+	path string
+
+	mu       sync.RWMutex
+	modTime  time.Time
+	fallback string
+	keys     map[string]string
+	names    map[string]cachedNames
+}
+
+// cachedNames are the other names of an address, as of when they were looked up
+type cachedNames struct {
+	names   []string
+	expires time.Time
+}
+
+const (
+	// lookupTimeout bounds looking up an address's other names
+	lookupTimeout = 2 * time.Second
+
+	// nameTTL is how long an address's other names are cached for, and maxNames is the most
+	// addresses that are cached
+	nameTTL  = 10 * time.Minute
+	maxNames = 1024
+)
+
+type credentialFile struct {
+	Default string            `json:"default"`
+	Devices map[string]string `json:"devices"`
+}
+
+// Credentials is the store used for every request we send to a TV
+var Credentials = &CredentialStore{}
+
+// NewCredentialStore builds a store from the file or directory at path
+func NewCredentialStore(path string) (*CredentialStore, error) {
+	c := &CredentialStore{
+		path: path,
+	}
+
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Reload rereads the store's file or directory
+func (c *CredentialStore) Reload() error {
+	if c.path == "" {
+		return nil
+	}
+
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return fmt.Errorf("unable to read credentials: %w", err)
+	}
+
+	var creds credentialFile
+	if info.IsDir() {
+		creds, err = readCredentialDir(c.path)
+	} else {
+		creds, err = readCredentialFile(c.path)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]string, len(creds.Devices))
+	for k, v := range creds.Devices {
+		keys[normalizeHost(k)] = v
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.modTime = info.ModTime()
+	c.fallback = creds.Default
+	c.keys = keys
+	c.names = make(map[string]cachedNames)
+
+	return nil
+}
+
+func readCredentialFile(path string) (credentialFile, error) {
+	var creds credentialFile
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return creds, fmt.Errorf("unable to read credentials: %w", err)
+	}
+
+	if err := json.Unmarshal(b, &creds); err != nil {
+		return creds, fmt.Errorf("unable to parse credentials: %w", err)
+	}
+
+	return creds, nil
+}
+
+func readCredentialDir(path string) (credentialFile, error) {
+	creds := credentialFile{
+		Devices: make(map[string]string),
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return creds, fmt.Errorf("unable to read credentials: %w", err)
+	}
+
+	for _, entry := range entries {
+		// skip the hidden ..data links kubernetes puts in mounted secrets
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		b, err := os.ReadFile(filepath.Join(path, entry.Name()))
+		if err != nil {
+			return creds, fmt.Errorf("unable to read credentials: %w", err)
+		}
+
+		key := strings.TrimSpace(string(b))
+		if entry.Name() == "default" {
+			creds.Default = key
+		} else {
+			creds.Devices[entry.Name()] = key
+		}
+	}
+
+	return creds, nil
+}
+
+// Watch reloads the store whenever its file or directory changes. It never returns
+func (c *CredentialStore) Watch(interval time.Duration, log *zap.Logger) {
+	if c.path == "" {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		info, err := os.Stat(c.path)
+		if err != nil {
+			log.Warn("unable to check credentials", zap.String("path", c.path), zap.Error(err))
+			continue
+		}
+
+		c.mu.RLock()
+		changed := !info.ModTime().Equal(c.modTime)
+		c.mu.RUnlock()
+
+		if !changed {
+			continue
+		}
+
+		if err := c.Reload(); err != nil {
+			log.Error("unable to reload credentials", zap.String("path", c.path), zap.Error(err))
+			continue
+		}
+
+		log.Info("reloaded credentials", zap.String("path", c.path))
+	}
+}
+
+// PSK returns the pre-shared key for the TV at address
+func (c *CredentialStore) PSK(ctx context.Context, address string) string {
+	c.mu.RLock()
+	key, ok := c.keys[normalizeHost(address)]
+	fallback := c.fallback
+	empty := len(c.keys) == 0
+	c.mu.RUnlock()
+
+	if ok {
+		return key
+	}
+
+	// try the address's other names, e.g. its hostname if we were given an ip
+	if !empty {
+		for _, name := range c.lookupNames(ctx, address) {
+			c.mu.RLock()
+			key, ok := c.keys[name]
+			c.mu.RUnlock()
+
+			if ok {
+				return key
+			}
+		}
+	}
+
+	if fallback != "" {
+		return fallback
+	}
+
+	return os.Getenv("SONY_TV_PSK")
+}
+
+// lookupNames resolves the hostnames (for an ip) or ips (for a hostname) of address. Addresses come
+// from requests, so only ones in the inventory or allowed by the allowlist are looked up. Results are
+// cached for nameTTL, or until the next reload
+func (c *CredentialStore) lookupNames(ctx context.Context, address string) []string {
+	host := normalizeHost(address)
+
+	c.mu.RLock()
+	entry, ok := c.names[host]
+	c.mu.RUnlock()
+
+	if ok && time.Now().Before(entry.expires) {
+		return entry.names
+	}
+
+	if _, known := Inventory.Lookup(address); !known {
+		if _, err := Allowlist.Check(ctx, host); err != nil {
+			return nil
+		}
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+
+	var names []string
+	var err error
+	if net.ParseIP(host) != nil {
+		names, err = net.DefaultResolver.LookupAddr(lookupCtx, host)
+	} else {
+		names, err = net.DefaultResolver.LookupHost(lookupCtx, host)
+	}
+
+	if err != nil {
+		// don't remember a lookup that failed because the request went away
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		names = nil
+	}
+
+	for i := range names {
+		names[i] = normalizeHost(names[i])
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.names == nil {
+		c.names = make(map[string]cachedNames)
+	}
+
+	if len(c.names) >= maxNames {
+		now := time.Now()
+		for name, entry := range c.names {
+			if now.After(entry.expires) {
+				delete(c.names, name)
+			}
+		}
+
+		// every entry is still fresh, so start over rather than growing past maxNames
+		if len(c.names) >= maxNames {
+			c.names = make(map[string]cachedNames)
+		}
+	}
+
+	c.names[host] = cachedNames{names: names, expires: time.Now().Add(nameTTL)}
+	return names
+}
+
+// normalizeHost strips any port and trailing dot from address and lowercases it
+func normalizeHost(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+
+	return strings.ToLower(strings.TrimSuffix(address, "."))
+}
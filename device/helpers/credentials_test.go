@@ -0,0 +1,190 @@
+package helpers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/byuoitav/sony-control-microservice/device/allowlist"
+	"github.com/byuoitav/sony-control-microservice/device/inventory"
+	"go.uber.org/zap"
+)
+
+// denyTestNetwork swaps the allowlist for one that denies 10.0.0.0/8, so that looking up the names of
+// addresses there never reaches dns
+func denyTestNetwork(t *testing.T) {
+	t.Helper()
+
+	list, err := allowlist.New(nil, []string{"10.0.0.0/8"}, nil, nil)
+	if err != nil {
+		t.Fatalf("unable to build allowlist: %s", err)
+	}
+
+	before := Allowlist
+	Allowlist = list
+	t.Cleanup(func() { Allowlist = before })
+}
+
+// writeFile writes contents to name in dir, failing the test if it can't
+func writeFile(t *testing.T, dir, name, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("unable to write %s: %s", name, err)
+	}
+
+	return path
+}
+
+// expectPSK fails the test unless c's key for address is want
+func expectPSK(t *testing.T, c *CredentialStore, address, want string) {
+	t.Helper()
+
+	if got := c.PSK(context.Background(), address); got != want {
+		t.Fatalf("got key %q for %s, want %q", got, address, want)
+	}
+}
+
+func TestCredentialFile(t *testing.T) {
+	denyTestNetwork(t)
+	t.Setenv("SONY_TV_PSK", "env-key")
+
+	path := writeFile(t, t.TempDir(), "credentials.json", `{"default": "default-key", "devices": {"10.5.34.12": "key2", "ITB-1101-D1.byu.edu.": "key3"}}`)
+
+	c, err := NewCredentialStore(path)
+	if err != nil {
+		t.Fatalf("unable to load credentials: %s", err)
+	}
+
+	expectPSK(t, c, "10.5.34.12", "key2")
+	expectPSK(t, c, "10.5.34.12:80", "key2")
+	expectPSK(t, c, "itb-1101-d1.byu.edu", "key3")
+
+	// the default wins over the environment
+	expectPSK(t, c, "10.5.34.13", "default-key")
+}
+
+func TestCredentialDir(t *testing.T) {
+	denyTestNetwork(t)
+	t.Setenv("SONY_TV_PSK", "env-key")
+
+	dir := t.TempDir()
+	writeFile(t, dir, "default", "default-key\n")
+	writeFile(t, dir, "10.5.34.12", "key2\n")
+	writeFile(t, dir, "ITB-1101-D1.byu.edu", "key3")
+	writeFile(t, dir, ".10.5.34.14", "hidden-key")
+
+	// like the timestamped directories kubernetes mounts secrets from
+	if err := os.Mkdir(filepath.Join(dir, "10.5.34.15"), 0700); err != nil {
+		t.Fatalf("unable to make directory: %s", err)
+	}
+
+	c, err := NewCredentialStore(dir)
+	if err != nil {
+		t.Fatalf("unable to load credentials: %s", err)
+	}
+
+	expectPSK(t, c, "10.5.34.12", "key2")
+	expectPSK(t, c, "itb-1101-d1.byu.edu:80", "key3")
+	expectPSK(t, c, "10.5.34.13", "default-key")
+
+	// hidden files and directories aren't keys
+	expectPSK(t, c, "10.5.34.14", "default-key")
+	expectPSK(t, c, "10.5.34.15", "default-key")
+}
+
+func TestCredentialEnvFallback(t *testing.T) {
+	denyTestNetwork(t)
+	t.Setenv("SONY_TV_PSK", "env-key")
+
+	// nothing configured at all
+	expectPSK(t, &CredentialStore{}, "10.5.34.12", "env-key")
+
+	path := writeFile(t, t.TempDir(), "credentials.json", `{"devices": {"10.5.34.12": "key2"}}`)
+
+	c, err := NewCredentialStore(path)
+	if err != nil {
+		t.Fatalf("unable to load credentials: %s", err)
+	}
+
+	expectPSK(t, c, "10.5.34.12", "key2")
+	expectPSK(t, c, "10.5.34.13", "env-key")
+}
+
+func TestCredentialWatch(t *testing.T) {
+	denyTestNetwork(t)
+
+	path := writeFile(t, t.TempDir(), "credentials.json", `{"devices": {"10.5.34.12": "key2"}}`)
+
+	c, err := NewCredentialStore(path)
+	if err != nil {
+		t.Fatalf("unable to load credentials: %s", err)
+	}
+
+	// Watch never returns, so it can't log to the test after the test is done
+	go c.Watch(10*time.Millisecond, zap.NewNop())
+
+	writeFile(t, filepath.Dir(path), "credentials.json", `{"devices": {"10.5.34.12": "key2", "10.5.34.13": "new-key"}}`)
+
+	// make sure the change is noticed, even if the filesystem's timestamps are too coarse to tell
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatalf("unable to change mtime: %s", err)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if c.PSK(context.Background(), "10.5.34.13") == "new-key" {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("new key wasn't picked up after the file changed")
+		}
+	}
+
+	expectPSK(t, c, "10.5.34.12", "key2")
+}
+
+func TestCredentialNamesOnlyForKnownAddresses(t *testing.T) {
+	denyTestNetwork(t)
+
+	inv, err := inventory.Load(writeFile(t, t.TempDir(), "inventory.json", `{"devices": {"ITB-1101-D1": {"address": "10.5.34.20:80"}}}`))
+	if err != nil {
+		t.Fatalf("unable to load inventory: %s", err)
+	}
+
+	before := Inventory
+	Inventory = inv
+	t.Cleanup(func() { Inventory = before })
+
+	path := writeFile(t, t.TempDir(), "credentials.json", `{"default": "default-key", "devices": {"itb-1101-d1.byu.edu": "key3"}}`)
+
+	c, err := NewCredentialStore(path)
+	if err != nil {
+		t.Fatalf("unable to load credentials: %s", err)
+	}
+
+	tests := []struct {
+		address  string
+		lookedUp bool
+	}{
+		{"10.5.34.99", false},   // neither in the inventory nor allowed
+		{"10.5.34.20:80", true}, // in the inventory, even though the allowlist denies it
+		{"127.0.0.1", true},     // allowed by the allowlist
+	}
+
+	for _, tt := range tests {
+		c.PSK(context.Background(), tt.address)
+
+		c.mu.RLock()
+		_, lookedUp := c.names[normalizeHost(tt.address)]
+		c.mu.RUnlock()
+
+		if lookedUp != tt.lookedUp {
+			t.Errorf("%s: looked up names: %v, want %v", tt.address, lookedUp, tt.lookedUp)
+		}
+	}
+}
@@ -2,22 +2,23 @@ package helpers
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"net"
 	"strings"
 
-	"github.com/byuoitav/common/nerr"
 	"github.com/byuoitav/common/structs"
+	"github.com/byuoitav/sony-control-microservice/device/scalar"
 	"go.uber.org/zap"
 )
 
 // GetHardwareInfo returns the hardware information for the device
-func GetHardwareInfo(address string, d DeviceManagerInterface) (structs.HardwareInfo, *nerr.E) {
+func GetHardwareInfo(ctx context.Context, address string, d DeviceManagerInterface) (structs.HardwareInfo, error) {
 	var toReturn structs.HardwareInfo
 
 	// get the hostname
-	addr, e := net.LookupAddr(address)
+	lookupCtx, cancel := context.WithTimeout(ctx, readTimeout)
+	addr, e := net.DefaultResolver.LookupAddr(lookupCtx, address)
+	cancel()
 	if e != nil {
 		toReturn.Hostname = address
 	} else {
@@ -25,11 +26,10 @@ func GetHardwareInfo(address string, d DeviceManagerInterface) (structs.Hardware
 	}
 
 	// get Sony TV system information
-	systemInfo, err := getSystemInfo(address)
+	systemInfo, err := getSystemInfo(ctx, address)
 	if err != nil {
 		d.GetLogger().Error("Could not get system info", zap.Error(err))
-		err.Addf("Could not get system info from %s", address)
-		return toReturn, err
+		return toReturn, fmt.Errorf("could not get system info from %s: %w", address, err)
 	}
 
 	toReturn.ModelName = systemInfo.Model
@@ -37,11 +37,10 @@ func GetHardwareInfo(address string, d DeviceManagerInterface) (structs.Hardware
 	toReturn.FirmwareVersion = systemInfo.Generation
 
 	// get Sony TV network settings
-	networkInfo, err := getNetworkInfo(address)
+	networkInfo, err := getNetworkInfo(ctx, address)
 	if err != nil {
 		d.GetLogger().Error("Could not get network info", zap.Error(err))
-		err.Addf("Could not get network info from %s", address)
-		return toReturn, err
+		return toReturn, fmt.Errorf("could not get network info from %s: %w", address, err)
 	}
 
 	toReturn.NetworkInfo = structs.NetworkInfo{
@@ -58,12 +57,10 @@ func GetHardwareInfo(address string, d DeviceManagerInterface) (structs.Hardware
 		toReturn.NetworkInfo.MACAddress, toReturn.NetworkInfo.Gateway, toReturn.NetworkInfo.DNS), zap.String("address", toReturn.NetworkInfo.IPAddress))
 
 	// get power status
-	powerStatus, e := GetPower(context.TODO(), address)
-	if e != nil {
-		d.GetLogger().Error("Could not get power status", zap.Error(e))
-		err = nerr.Translate(e).Addf("Could not get power status from %s", address)
-
-		return toReturn, err
+	powerStatus, err := GetPower(ctx, address)
+	if err != nil {
+		d.GetLogger().Error("Could not get power status", zap.Error(err))
+		return toReturn, fmt.Errorf("could not get power status from %s: %w", address, err)
 	}
 
 	toReturn.PowerStatus = powerStatus.Power
@@ -71,51 +68,52 @@ func GetHardwareInfo(address string, d DeviceManagerInterface) (structs.Hardware
 	return toReturn, nil
 }
 
-func getSystemInfo(address string) (SonySystemInformation, *nerr.E) {
-	var system SonyTVSystemResponse
-
-	payload := SonyTVRequest{
-		Params: []map[string]interface{}{},
-		Method: "getSystemInformation", Version: "1.0",
-		ID: 1,
-	}
+// Identity is the basic hardware identity of a TV
+type Identity struct {
+	Model           string `json:"model"`
+	SerialNumber    string `json:"serialNumber"`
+	FirmwareVersion string `json:"firmwareVersion"`
+	MACAddress      string `json:"macAddress,omitempty"`
+}
 
-	response, err := PostHTTP(address, payload, "system")
+// GetIdentity returns the TV's model, serial number, firmware version and MAC address
+func GetIdentity(ctx context.Context, address string, d DeviceManagerInterface) (Identity, error) {
+	systemInfo, err := getSystemInfo(ctx, address)
 	if err != nil {
-		return SonySystemInformation{}, nerr.Translate(err)
+		d.GetLogger().Error("Could not get system info", zap.Error(err))
+		return Identity{}, fmt.Errorf("could not get system info from %s: %w", address, err)
 	}
 
-	err = json.Unmarshal(response, &system)
-	if err != nil {
-		return SonySystemInformation{}, nerr.Translate(err)
-	}
+	return Identity{
+		Model:           systemInfo.Model,
+		SerialNumber:    systemInfo.Serial,
+		FirmwareVersion: systemInfo.Generation,
+		MACAddress:      systemInfo.MAC,
+	}, nil
+}
 
-	return system.Result[0], nil
+func getSystemInfo(ctx context.Context, address string) (scalar.SystemInformation, error) {
+	ctx, cancel := context.WithTimeout(ctx, readTimeout)
+	defer cancel()
+
+	return scalar.GetSystemInformation.Call(ctx, Client, address)
 }
 
-func getNetworkInfo(address string) (SonyTVNetworkInformation, *nerr.E) {
-	var network SonyNetworkResponse
-
-	payload := SonyTVRequest{
-		ID:      2,
-		Method:  "getNetworkSettings",
-		Version: "1.0",
-		Params: []map[string]interface{}{
-			map[string]interface{}{
-				"netif": "eth0",
-			},
-		},
-	}
+func getNetworkInfo(ctx context.Context, address string) (scalar.NetworkSettings, error) {
+	ctx, cancel := context.WithTimeout(ctx, readTimeout)
+	defer cancel()
 
-	response, err := PostHTTP(address, payload, "system")
+	network, err := scalar.GetNetworkSettings.Call(ctx, Client, address, scalar.NetworkSettingsParams{
+		NetworkInterface: "eth0",
+	})
 	if err != nil {
-		return SonyTVNetworkInformation{}, nerr.Translate(err)
+		return scalar.NetworkSettings{}, err
 	}
 
-	err = json.Unmarshal(response, &network)
-	if err != nil {
-		return SonyTVNetworkInformation{}, nerr.Translate(err)
+	if len(network) == 0 {
+		return scalar.NetworkSettings{}, fmt.Errorf("no network interfaces in response from %s", address)
 	}
 
-	return network.Result[0][0], nil
+	cacheNetworkInfo(address, network[0])
+	return network[0], nil
 }
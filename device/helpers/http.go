@@ -1,140 +1,42 @@
 package helpers
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
-	"errors"
-	"fmt"
-	"io"
-	"net/http"
-	"os"
-)
-
-// SonyAudioResponse is the parent struct returned when we query audio state
-type SonyAudioResponse struct {
-	Result [][]SonyAudioSettings `json:"result"`
-	ID     int                   `json:"id"`
-}
-
-// SonyAudioSettings is the child struct returned
-type SonyAudioSettings struct {
-	Target    string `json:"target"`
-	Volume    int    `json:"volume"`
-	Mute      bool   `json:"mute"`
-	MaxVolume int    `json:"maxVolume"`
-	MinVolume int    `json:"minVolume"`
-}
-
-type SonyAVContentSettings struct {
-	URI        string `json:"uri"`
-	Source     string `json:"source"`
-	Title      string `json:"title"`
-	Status     string `json:"status"`
-	Connection bool   `json:"connection"`
-}
-
-type SonyAVContentResponse struct {
-	Result []SonyAVContentSettings `json:"result"`
-	ID     int                     `json:"id"`
-}
-
-type SonyMultiAVContentResponse struct {
-	Result [][]SonyAVContentSettings `json:"result"`
-	ID     int                       `json:"id"`
-}
-
-// SonyTVRequest represents the struct we need to send.
-type SonyTVRequest struct {
-	Method  string                   `json:"method"`
-	Version string                   `json:"version"`
-	ID      int                      `json:"id"`
-	Params  []map[string]interface{} `json:"params"`
-}
-
-type SonyTVSystemResponse struct {
-	ID     int `json:"id"`
-	Result []SonySystemInformation
-}
-
-type SonySystemInformation struct {
-	Product    string `json:"product"`
-	Region     string `json:"region,omitempty"`
-	Language   string `json:"language,omitempty"`
-	Model      string `json:"model"`
-	Serial     string `json:"serial,omitempty"`
-	MAC        string `json:"macAddr,omitempty"`
-	Name       string `json:"name"`
-	Generation string `json:"generation,omitempty"`
-	Area       string `json:"area,omitempty"`
-	CID        string `json:"cid,omitempty"`
-}
-
-type SonyNetworkResponse struct {
-	ID     int `json:"id"`
-	Result [][]SonyTVNetworkInformation
-}
-
-type SonyTVNetworkInformation struct {
-	NetworkInterface string   `json:"netif"`
-	HardwareAddress  string   `json:"hwAddr"`
-	IPv4             string   `json:"ipAddrV4"`
-	IPv6             string   `json:"ipAddrV6"`
-	Netmask          string   `json:"netmask"`
-	Gateway          string   `json:"gateway"`
-	DNS              []string `json:"dns"`
-}
+	"time"
 
-func PostHTTPWithContext(ctx context.Context, address, service string, payload SonyTVRequest) ([]byte, error) {
-	reqBody, err := json.Marshal(payload)
-	if err != nil {
-		return []byte{}, err
-	}
-
-	addr := fmt.Sprintf("http://%s/sony/%s", address, service)
-
-	req, err := http.NewRequestWithContext(ctx, "POST", addr, bytes.NewBuffer(reqBody))
-	if err != nil {
-		return []byte{}, err
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Auth-PSK", os.Getenv("SONY_TV_PSK"))
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return []byte{}, err
-	}
-	defer resp.Body.Close()
+	"github.com/byuoitav/sony-control-microservice/device/allowlist"
+	"github.com/byuoitav/sony-control-microservice/device/inventory"
+	"github.com/byuoitav/sony-control-microservice/device/scalar"
+	"go.uber.org/zap"
+)
 
-	body, err := io.ReadAll(resp.Body)
-	switch {
-	case err != nil:
-		return []byte{}, err
-	case resp.StatusCode != http.StatusOK:
-		return []byte{}, errors.New(string(body))
-	case body == nil:
-		return []byte{}, errors.New("response from device was blank")
-	}
+// Default deadlines for each kind of call to a TV (power transitions are bounded by their caller). They're applied on top of the caller's context, so
+// that a hung TV can't hold a request (or the actions queued behind it) forever
+const (
+	// readTimeout bounds reading the TV's state
+	readTimeout = 5 * time.Second
 
-	return body, nil
-}
+	// writeTimeout bounds changing the TV's state, other than its power
+	writeTimeout = 10 * time.Second
+)
 
-// PostHTTP just sends a request
-func PostHTTP(address string, payload SonyTVRequest, service string) ([]byte, error) {
-	return PostHTTPWithContext(context.TODO(), address, service, payload)
+type DeviceManagerInterface interface {
+	GetLogger() *zap.Logger
 }
 
-func BuildAndSendPayload(address string, service string, method string, params map[string]interface{}) error {
-	payload := SonyTVRequest{
-		Params:  []map[string]interface{}{params},
-		Method:  method,
-		Version: "1.0",
-		ID:      1,
-	}
+// Inventory is the set of named devices we know about. It's empty unless an inventory file is loaded
+var Inventory *inventory.Inventory
 
-	_, err := PostHTTP(address, payload, service)
+// Allowlist is the set of hosts we may connect to. Every host is allowed if it's nil
+var Allowlist *allowlist.List
 
-	return err
+// Client is used for every request we send to a TV. A key in the inventory wins over the credential store
+var Client = &scalar.Client{
+	PSK: func(ctx context.Context, address string) string {
+		if dev, ok := Inventory.Lookup(address); ok && dev.PSK != "" {
+			return dev.PSK
+		}
 
+		return Credentials.PSK(ctx, address)
+	},
 }
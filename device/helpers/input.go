@@ -2,22 +2,22 @@ package helpers
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"regexp"
+	"strings"
 
-	"github.com/byuoitav/common/nerr"
 	"go.uber.org/zap"
 
 	"github.com/byuoitav/common/status"
 	"github.com/byuoitav/common/structs"
+	"github.com/byuoitav/sony-control-microservice/device/scalar"
 )
 
 // GetInput gets the input that is currently being shown on the TV
-func GetInput(address string, d DeviceManagerInterface) (status.Input, error) {
+func GetInput(ctx context.Context, address string, d DeviceManagerInterface) (status.Input, error) {
 	var output status.Input
 
-	pwrState, err := GetPower(context.TODO(), address)
+	pwrState, err := GetPower(ctx, address)
 	if err != nil {
 		d.GetLogger().Error("Failed to get power state", zap.Error(err))
 		return output, err
@@ -26,76 +26,132 @@ func GetInput(address string, d DeviceManagerInterface) (status.Input, error) {
 		return output, nil
 	}
 
-	payload := SonyTVRequest{
-		Params:  []map[string]interface{}{},
-		Method:  "getPlayingContentInfo",
-		ID:      1,
-		Version: "1.0",
-	}
+	return GetCurrentInput(ctx, address, d)
+}
+
+// GetCurrentInput gets the input that is currently being shown on a TV that is on
+func GetCurrentInput(ctx context.Context, address string, d DeviceManagerInterface) (status.Input, error) {
+	var output status.Input
+
+	ctx, cancel := context.WithTimeout(ctx, readTimeout)
+	defer cancel()
 
-	response, err := PostHTTP(address, payload, "avContent")
+	content, err := scalar.GetPlayingContentInfo.Call(ctx, Client, address)
 	if err != nil {
 		d.GetLogger().Error(fmt.Sprintf("Faild to post to %s", address),
 			zap.String("address", address), zap.Error(err))
 		return output, err
 	}
 
-	var outputStruct SonyAVContentResponse
-	err = json.Unmarshal(response, &outputStruct)
-	if err != nil || len(outputStruct.Result) < 1 {
-		return output, err
+	d.GetLogger().Debug(fmt.Sprintf("%+v", content))
+
+	port, ok := ParsePort(content.URI)
+	if !ok {
+		return output, fmt.Errorf("unknown input uri from %s: %s", address, content.URI)
 	}
-	//we need to parse the response for the value
+	output.Input = port
+
+	d.GetLogger().Info(fmt.Sprintf("Current Input for %s: %s", address, output.Input))
 
-	d.GetLogger().Debug(fmt.Sprintf("%+v", outputStruct))
+	return output, nil
+}
 
-	regexStr := `extInput:(.*?)\?port=(.*)`
-	re := regexp.MustCompile(regexStr)
+// SetInput switches the TV to port, which should follow the format "hdmi!2"
+func SetInput(ctx context.Context, address, port string) error {
+	splitPort := strings.Split(port, "!")
+	if len(splitPort) < 2 {
+		return fmt.Errorf("ports configured incorrectly (should follow format \"hdmi!2\"): %s", port)
+	}
 
-	matches := re.FindStringSubmatch(outputStruct.Result[0].URI)
-	output.Input = fmt.Sprintf("%v!%v", matches[1], matches[2])
+	ctx, cancel := context.WithTimeout(ctx, writeTimeout)
+	defer cancel()
 
-	d.GetLogger().Info(fmt.Sprintf("Current Input for %s: %s", address, output.Input))
+	_, err := scalar.SetPlayContent.Call(ctx, Client, address, scalar.SetPlayContentParams{
+		URI: fmt.Sprintf("extInput:%s?port=%s", splitPort[0], splitPort[1]),
+	})
+	return err
+}
 
-	return output, nil
+// InputInfo describes a single external input reported by the TV
+type InputInfo struct {
+	Port       string `json:"port"`
+	Alias      string `json:"alias,omitempty"`
+	Title      string `json:"title"`
+	Label      string `json:"label,omitempty"`
+	Icon       string `json:"icon,omitempty"`
+	Connection bool   `json:"connection"`
+	Signal     bool   `json:"signal"`
 }
 
-// GetActiveSignal determines if the current input on the TV is active or not
-func GetActiveSignal(address, port string, d DeviceManagerInterface) (structs.ActiveSignal, *nerr.E) {
-	var output structs.ActiveSignal
+var inputURIRegex = regexp.MustCompile(`extInput:(.*?)\?port=(.*)`)
 
-	payload := SonyTVRequest{
-		Params:  []map[string]interface{}{},
-		Method:  "getCurrentExternalInputsStatus",
-		ID:      1,
-		Version: "1.1",
+// ParsePort converts a sony input uri (extInput:hdmi?port=2) into our port format (hdmi!2)
+func ParsePort(uri string) (string, bool) {
+	matches := inputURIRegex.FindStringSubmatch(uri)
+	if len(matches) < 3 {
+		return "", false
 	}
 
-	response, err := PostHTTP(address, payload, "avContent")
+	return fmt.Sprintf("%v!%v", matches[1], matches[2]), true
+}
+
+func getExternalInputsStatus(ctx context.Context, address string, d DeviceManagerInterface) ([]scalar.ExternalInputStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, readTimeout)
+	defer cancel()
+
+	inputs, err := scalar.GetCurrentExternalInputsStatus.Call(ctx, Client, address)
 	if err != nil {
 		d.GetLogger().Error(fmt.Sprintf("Faild to post to %s", address), zap.String("address", address), zap.Error(err))
-		return output, nerr.Translate(err)
+		return nil, err
 	}
 
-	var outputStruct SonyMultiAVContentResponse
-	err = json.Unmarshal(response, &outputStruct)
-	if err != nil || len(outputStruct.Result) < 1 {
-		d.GetLogger().Error("Failed to unmarshal response", zap.Error(err))
-		return output, nerr.Translate(err)
+	d.GetLogger().Debug(fmt.Sprintf("%+v", inputs))
+
+	return inputs, nil
+}
+
+// GetInputList returns every external input the TV reports
+func GetInputList(ctx context.Context, address string, d DeviceManagerInterface) ([]InputInfo, error) {
+	inputs, err := getExternalInputsStatus(ctx, address, d)
+	if err != nil {
+		return nil, err
 	}
-	//we need to parse the response for the value
 
-	d.GetLogger().Debug(fmt.Sprintf("%+v", outputStruct))
+	output := []InputInfo{}
+	for _, input := range inputs {
+		port, ok := ParsePort(input.URI)
+		if !ok {
+			d.GetLogger().Debug(fmt.Sprintf("Skipping unknown input uri %s", input.URI), zap.String("address", address))
+			continue
+		}
 
-	regexStr := `extInput:(.*?)\?port=(.*)`
-	re := regexp.MustCompile(regexStr)
+		output = append(output, InputInfo{
+			Port:       port,
+			Title:      input.Title,
+			Label:      input.Label,
+			Icon:       input.Icon,
+			Connection: input.Connection,
+			Signal:     input.Status == "true",
+		})
+	}
 
-	for _, result := range outputStruct.Result[0] {
-		if result.Status == "true" {
-			matches := re.FindStringSubmatch(result.URI)
-			tempActive := fmt.Sprintf("%v!%v", matches[1], matches[2])
+	return output, nil
+}
+
+// GetActiveSignal determines if the current input on the TV is active or not
+func GetActiveSignal(ctx context.Context, address, port string, d DeviceManagerInterface) (structs.ActiveSignal, error) {
+	var output structs.ActiveSignal
+
+	inputs, err := getExternalInputsStatus(ctx, address, d)
+	if err != nil {
+		return output, err
+	}
 
-			output.Active = (tempActive == port)
+	for _, input := range inputs {
+		if input.Status == "true" {
+			if tempActive, ok := ParsePort(input.URI); ok && tempActive == port {
+				output.Active = true
+			}
 		}
 	}
 
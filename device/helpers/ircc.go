@@ -0,0 +1,70 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/byuoitav/sony-control-microservice/device/scalar"
+	"go.uber.org/zap"
+)
+
+// ErrUnknownRemoteKey is returned when a TV doesn't have a code for the requested remote key
+var ErrUnknownRemoteKey = errors.New("unknown remote key")
+
+// remoteCodes caches each TV's code table, keyed by address
+var remoteCodes = struct {
+	sync.Mutex
+	codes map[string][]scalar.RemoteCode
+}{
+	codes: make(map[string][]scalar.RemoteCode),
+}
+
+// GetRemoteCodes returns the IRCC code table for the TV, fetching it the first time it's needed
+func GetRemoteCodes(ctx context.Context, address string, d DeviceManagerInterface) ([]scalar.RemoteCode, error) {
+	remoteCodes.Lock()
+	codes, ok := remoteCodes.codes[address]
+	remoteCodes.Unlock()
+
+	if ok {
+		return codes, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, readTimeout)
+	defer cancel()
+
+	codes, err := Client.RemoteCodes(ctx, address)
+	if err != nil {
+		d.GetLogger().Error("Failed to get remote controller info", zap.String("address", address), zap.Error(err))
+		return nil, err
+	}
+
+	remoteCodes.Lock()
+	remoteCodes.codes[address] = codes
+	remoteCodes.Unlock()
+
+	return codes, nil
+}
+
+// SendRemoteKey looks up the IRCC code for key (case insensitive) and sends it to the TV
+func SendRemoteKey(ctx context.Context, address, key string, d DeviceManagerInterface) error {
+	codes, err := GetRemoteCodes(ctx, address, d)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range codes {
+		if strings.EqualFold(code.Name, key) {
+			d.GetLogger().Info(fmt.Sprintf("Sending remote key %s to %s", code.Name, address), zap.String("address", address))
+
+			ctx, cancel := context.WithTimeout(ctx, writeTimeout)
+			defer cancel()
+
+			return Client.SendIRCC(ctx, address, code.Value)
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrUnknownRemoteKey, key)
+}
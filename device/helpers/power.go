@@ -4,77 +4,182 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"strings"
+	"net"
 	"time"
 
 	"github.com/byuoitav/common/status"
+	"github.com/byuoitav/sony-control-microservice/device/allowlist"
+	"github.com/byuoitav/sony-control-microservice/device/scalar"
+	"go.uber.org/zap"
 )
 
-func SetPower(ctx context.Context, address string, status bool, d DeviceManagerInterface) error {
-	params := make(map[string]interface{})
-	params["status"] = status
+// wakeTimeout is how long we wait for the TV to answer a power on request before
+// assuming its network stack is asleep and sending a wake-on-lan packet
+const wakeTimeout = 5 * time.Second
 
-	payload := SonyTVRequest{
-		Params:  []map[string]interface{}{params},
-		Method:  "setPowerStatus",
-		Version: "1.0",
-		ID:      1,
+// PowerTransitionError is returned when a TV doesn't reach the power status it was asked for before
+// the context given to SetPower is done
+type PowerTransitionError struct {
+	Address string
+	Target  string
+
+	// Last is the last power status the TV reported, if it reported one
+	Last    string
+	Elapsed time.Duration
+
+	// Err is why we stopped waiting, i.e. context.DeadlineExceeded or context.Canceled
+	Err error
+}
+
+func (e *PowerTransitionError) Error() string {
+	last := e.Last
+	if last == "" {
+		last = "unknown"
 	}
 
+	return fmt.Sprintf("%s didn't change power to %s after %s (last status %s): %s", e.Address, e.Target, e.Elapsed.Round(time.Millisecond), last, e.Err)
+}
+
+func (e *PowerTransitionError) Unwrap() error {
+	return e.Err
+}
+
+// SetPower turns the TV on (or to standby) and waits until it reports that it has. The wait is only
+// bounded by ctx, so callers should give it a deadline. If progress isn't nil, it's called with each
+// power status the TV reports while we wait
+func SetPower(ctx context.Context, address string, status bool, d DeviceManagerInterface, progress func(power string)) error {
+	params := scalar.SetPowerStatusParams{Status: status}
+
 	d.GetLogger().Info(fmt.Sprintf("Setting power to %v", status))
 
-	_, err := PostHTTPWithContext(ctx, address, "system", payload)
-	if err != nil {
-		return err
+	start := time.Now()
+	transition := &PowerTransitionError{Address: address, Target: "standby"}
+	if status {
+		transition.Target = "on"
+	}
+
+	// stopped is the error for giving up on the transition, once ctx is done
+	stopped := func() error {
+		transition.Elapsed = time.Since(start)
+		transition.Err = ctx.Err()
+		return transition
+	}
+
+	if !status {
+		// make sure we know the mac address before the tv goes to sleep
+		if _, ok := cachedNetworkInfo(address); !ok {
+			if _, err := getNetworkInfo(ctx, address); err != nil {
+				d.GetLogger().Warn("Unable to cache network info before standby", zap.String("address", address), zap.Error(err))
+			}
+		}
+
+		if _, err := scalar.SetPowerStatus.Call(ctx, Client, address, params); err != nil {
+			return err
+		}
+	}
+
+	woken := false
+	if status {
+		postCtx, cancel := context.WithTimeout(ctx, wakeTimeout)
+		_, err := scalar.SetPowerStatus.Call(postCtx, Client, address, params)
+		cancel()
+
+		switch {
+		case isNoResponse(err) && ctx.Err() == nil:
+			d.GetLogger().Info(fmt.Sprintf("No response from %s, sending wake-on-lan", address), zap.String("address", address), zap.Error(err))
+
+			if wolErr := SendWakeOnLAN(address); wolErr != nil {
+				return fmt.Errorf("no response from tv (%w) and unable to wake it: %s", err, wolErr)
+			}
+
+			woken = true
+		case err != nil:
+			return err
+		}
 	}
 
 	// wait for the display to turn on
 	ticker := time.NewTicker(256 * time.Millisecond)
 	defer ticker.Stop()
 
+	resent := false
 	for {
 		select {
 		case <-ctx.Done():
-			return errors.New("context timed out while waiting for display to turn on")
+			return stopped()
 		case <-ticker.C:
 			power, err := GetPower(ctx, address)
-			if err != nil {
+			switch {
+			case err != nil && ctx.Err() != nil:
+				return stopped()
+			case err != nil && woken && isNoResponse(err):
+				// the network stack is still waking up
+				continue
+			case err != nil:
 				return err
 			}
 
 			d.GetLogger().Info(fmt.Sprintf("Waiting for display power to change to %v, current status %s", status, power.Power))
 
+			transition.Last = power.Power
+			if progress != nil {
+				progress(power.Power)
+			}
+
 			switch {
 			case status && power.Power == "on":
 				return nil
 			case !status && power.Power == "standby":
 				return nil
+			case woken && !resent:
+				// the tv woke up into standby, so it never saw our first request
+				if _, err := scalar.SetPowerStatus.Call(ctx, Client, address, params); err != nil {
+					if ctx.Err() != nil {
+						return stopped()
+					}
+
+					return err
+				}
+
+				resent = true
 			}
 		}
 	}
 }
 
+// isNoResponse returns true if err means the TV never answered us. A connection the allowlist
+// refused never reached the TV, so it doesn't count
+func isNoResponse(err error) bool {
+	if err == nil || errors.Is(err, allowlist.ErrNotAllowed) {
+		return false
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
 func GetPower(ctx context.Context, address string) (status.Power, error) {
 	var output status.Power
 
-	payload := SonyTVRequest{
-		Params: []map[string]interface{}{},
-		Method: "getPowerStatus", Version: "1.0",
-		ID: 1,
-	}
+	ctx, cancel := context.WithTimeout(ctx, readTimeout)
+	defer cancel()
 
-	response, err := PostHTTPWithContext(ctx, address, "system", payload)
+	power, err := scalar.GetPowerStatus.Call(ctx, Client, address)
 	if err != nil {
 		return status.Power{}, err
 	}
 
-	powerStatus := string(response)
-	if strings.Contains(powerStatus, "active") {
+	switch power.Status {
+	case "active":
 		output.Power = "on"
-	} else if strings.Contains(powerStatus, "standby") {
+	case "standby":
 		output.Power = "standby"
-	} else {
-		return status.Power{}, errors.New("Error getting power status")
+	default:
+		return status.Power{}, fmt.Errorf("unknown power status from %s: %q", address, power.Status)
 	}
 
 	return output, nil
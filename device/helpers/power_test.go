@@ -0,0 +1,35 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/byuoitav/sony-control-microservice/device/allowlist"
+)
+
+func TestIsNoResponse(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	denied := fmt.Errorf("%w: 8.8.8.8 is a public address", allowlist.ErrNotAllowed)
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"deadline", context.DeadlineExceeded, true},
+		{"dial error", &url.Error{Op: "Post", URL: "http://10.0.0.1/sony/system", Err: refused}, true},
+		{"not allowed", &url.Error{Op: "Post", URL: "http://8.8.8.8/sony/system", Err: denied}, false},
+		{"other error", errors.New("bad response"), false},
+	}
+
+	for _, tt := range tests {
+		if got := isNoResponse(tt.err); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,77 +1,94 @@
 package helpers
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"strconv"
 
 	"github.com/byuoitav/common/status"
+	"github.com/byuoitav/sony-control-microservice/device/scalar"
 	"go.uber.org/zap"
 )
 
-func GetVolume(address string, d DeviceManagerInterface) (status.Volume, error) {
+func GetVolume(ctx context.Context, address string, d DeviceManagerInterface) (status.Volume, error) {
 	d.GetLogger().Info(fmt.Sprintf("Getting volume for %v", address))
-	parentResponse, err := getAudioInformation(address, d)
+	output, _, err := GetAudio(ctx, address, d)
 	if err != nil {
 		d.GetLogger().Error(fmt.Sprintf("Failed to get volume for %v", address), zap.String("address", address), zap.Error(err))
 		return status.Volume{}, err
 	}
-	d.GetLogger().Info(fmt.Sprintf("%v", parentResponse))
-
-	var output status.Volume
-	for _, outerResult := range parentResponse.Result {
+	d.GetLogger().Info("Done")
 
-		for _, result := range outerResult {
+	return output, nil
+}
 
-			if result.Target == "speaker" {
+// GetAudio gets the speaker's volume and mute status from a single request to the TV
+func GetAudio(ctx context.Context, address string, d DeviceManagerInterface) (status.Volume, status.Mute, error) {
+	targets, err := getAudioInformation(ctx, address, d)
+	if err != nil {
+		return status.Volume{}, status.Mute{}, err
+	}
 
-				output.Volume = result.Volume
-			}
+	var volume status.Volume
+	var mute status.Mute
+	for _, result := range targets {
+		if result.Target == "speaker" {
+			volume.Volume = result.Volume
+			mute.Muted = result.Mute
 		}
 	}
-	d.GetLogger().Info("Done")
 
-	return output, nil
+	return volume, mute, nil
 }
 
-func getAudioInformation(address string, d DeviceManagerInterface) (SonyAudioResponse, error) {
-	payload := SonyTVRequest{
-		Params:  []map[string]interface{}{},
-		Method:  "getVolumeInformation",
-		Version: "1.0",
-		ID:      1,
-	}
-
-	d.GetLogger().Info(fmt.Sprint("%+v", payload))
+func getAudioInformation(ctx context.Context, address string, d DeviceManagerInterface) ([]scalar.VolumeInformation, error) {
+	ctx, cancel := context.WithTimeout(ctx, readTimeout)
+	defer cancel()
 
-	resp, err := PostHTTP(address, payload, "audio")
+	targets, err := scalar.GetVolumeInformation.Call(ctx, Client, address)
 
-	parentResponse := SonyAudioResponse{}
+	d.GetLogger().Info(fmt.Sprintf("%+v", targets))
 
-	d.GetLogger().Info(fmt.Sprintf("%s", resp))
+	return targets, err
+}
 
-	err = json.Unmarshal(resp, &parentResponse)
-	return parentResponse, err
+// SetVolume sets the volume of both the speaker and the headphone
+func SetVolume(ctx context.Context, address string, volume int) error {
+	ctx, cancel := context.WithTimeout(ctx, writeTimeout)
+	defer cancel()
+
+	for _, target := range []string{"speaker", "headphone"} {
+		_, err := scalar.SetAudioVolume.Call(ctx, Client, address, scalar.SetAudioVolumeParams{
+			Target: target,
+			Volume: strconv.Itoa(volume),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to set %s volume: %w", target, err)
+		}
+	}
 
+	return nil
 }
 
-func GetMute(address string, d DeviceManagerInterface) (status.Mute, error) {
+func GetMute(ctx context.Context, address string, d DeviceManagerInterface) (status.Mute, error) {
 	d.GetLogger().Info(fmt.Sprintf("Getting mute status for %v", address))
-	parentResponse, err := getAudioInformation(address, d)
+	_, output, err := GetAudio(ctx, address, d)
 	if err != nil {
 		d.GetLogger().Error(fmt.Sprintf("Failed to get mute status for %v", address), zap.String("address", address), zap.Error(err))
 		return status.Mute{}, err
 	}
-	var output status.Mute
-	for _, outerResult := range parentResponse.Result {
-		for _, result := range outerResult {
-			if result.Target == "speaker" {
-				d.GetLogger().Info(fmt.Sprintf("local mute: %v", result.Mute))
-				output.Muted = result.Mute
-			}
-		}
-	}
+	d.GetLogger().Info(fmt.Sprintf("local mute: %v", output.Muted))
 
 	d.GetLogger().Info("Done")
 
 	return output, nil
 }
+
+// SetMute mutes or unmutes the TV
+func SetMute(ctx context.Context, address string, muted bool) error {
+	ctx, cancel := context.WithTimeout(ctx, writeTimeout)
+	defer cancel()
+
+	_, err := scalar.SetAudioMute.Call(ctx, Client, address, scalar.SetAudioMuteParams{Status: muted})
+	return err
+}
@@ -0,0 +1,110 @@
+package helpers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/byuoitav/sony-control-microservice/device/scalar"
+)
+
+// networkInfo caches each TV's network settings, keyed by address, so that we still
+// know its MAC address when its network stack is asleep
+var networkInfo = struct {
+	sync.Mutex
+	info map[string]scalar.NetworkSettings
+}{
+	info: make(map[string]scalar.NetworkSettings),
+}
+
+func cacheNetworkInfo(address string, info scalar.NetworkSettings) {
+	if info.HardwareAddress == "" {
+		return
+	}
+
+	networkInfo.Lock()
+	networkInfo.info[address] = info
+	networkInfo.Unlock()
+}
+
+func cachedNetworkInfo(address string) (scalar.NetworkSettings, bool) {
+	networkInfo.Lock()
+	defer networkInfo.Unlock()
+
+	info, ok := networkInfo.info[address]
+	return info, ok
+}
+
+// SendWakeOnLAN sends a magic packet for the TV at address. The MAC address comes from an earlier
+// call to getNetworkInfo, or if there hasn't been one since we started, from the TV's inventory entry
+func SendWakeOnLAN(address string) error {
+	info, ok := cachedNetworkInfo(address)
+	if !ok {
+		dev, known := Inventory.Lookup(address)
+		if !known || dev.MAC == "" {
+			return fmt.Errorf("no MAC address known for %s", address)
+		}
+
+		// we don't know the TV's netmask, so the packet can only go to the limited broadcast and the TV itself
+		info = scalar.NetworkSettings{HardwareAddress: dev.MAC}
+
+		host := address
+		if h, _, err := net.SplitHostPort(address); err == nil {
+			host = h
+		}
+
+		if net.ParseIP(host) != nil {
+			info.IPv4 = host
+		}
+	}
+
+	mac, err := net.ParseMAC(info.HardwareAddress)
+	if err != nil {
+		return fmt.Errorf("invalid MAC address for %s: %w", address, err)
+	}
+
+	packet := append(bytes.Repeat([]byte{0xff}, 6), bytes.Repeat(mac, 16)...)
+
+	// the limited broadcast won't leave our subnet, so also try the TV's directed broadcast
+	// and the TV itself (which works as long as the router still has it in its arp table)
+	targets := []string{"255.255.255.255"}
+	if ip := net.ParseIP(info.IPv4).To4(); ip != nil {
+		if mask := net.ParseIP(info.Netmask).To4(); mask != nil {
+			broadcast := make(net.IP, len(ip))
+			for i := range ip {
+				broadcast[i] = ip[i] | ^mask[i]
+			}
+
+			targets = append(targets, broadcast.String())
+		}
+
+		targets = append(targets, ip.String())
+	}
+
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var errs []error
+	for _, target := range targets {
+		addr, err := net.ResolveUDPAddr("udp4", net.JoinHostPort(target, "9"))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if _, err := conn.WriteTo(packet, addr); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) == len(targets) {
+		return fmt.Errorf("failed to send magic packet: %w", errors.Join(errs...))
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package helpers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/byuoitav/sony-control-microservice/device/inventory"
+)
+
+func TestWakeOnLANFromInventory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	file := `{"devices": {"ITB-1101-D1": {"address": "127.0.0.1:8080", "mac": "fc:f1:52:00:00:02"}, "ITB-1101-D2": {"address": "127.0.0.2:8080"}}}`
+	if err := os.WriteFile(path, []byte(file), 0600); err != nil {
+		t.Fatalf("unable to write inventory: %s", err)
+	}
+
+	inv, err := inventory.Load(path)
+	if err != nil {
+		t.Fatalf("unable to load inventory: %s", err)
+	}
+
+	before := Inventory
+	Inventory = inv
+	t.Cleanup(func() { Inventory = before })
+
+	// nothing has been learned from these tvs, so only the inventory knows a mac address
+	if err := SendWakeOnLAN("127.0.0.1:8080"); err != nil {
+		t.Fatalf("unable to wake a tv with a mac in the inventory: %s", err)
+	}
+
+	if err := SendWakeOnLAN("127.0.0.2:8080"); err == nil {
+		t.Fatal("woke a tv without a known mac address")
+	}
+}
@@ -0,0 +1,83 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/byuoitav/sony-control-microservice/device/helpers"
+	"github.com/byuoitav/sony-control-microservice/device/inventory"
+	"github.com/gin-gonic/gin"
+)
+
+// ErrPolicy is wrapped by errors for actions that a device's inventory policy doesn't allow
+var ErrPolicy = errors.New("not allowed by device policy")
+
+// resolveDevice is middleware that lets every route take a device id from the inventory in place
+// of its :address. Handlers only ever see the device's address
+func resolveDevice(context *gin.Context) {
+	for i := range context.Params {
+		if context.Params[i].Key != "address" {
+			continue
+		}
+
+		if dev, ok := helpers.Inventory.Lookup(context.Params[i].Value); ok {
+			context.Params[i].Value = dev.Address
+		}
+	}
+
+	context.Next()
+}
+
+// checkPolicy returns an error if the inventory policy of the device at address doesn't allow desired
+func checkPolicy(address string, desired DesiredState) error {
+	dev, ok := helpers.Inventory.Lookup(address)
+	if !ok {
+		return nil
+	}
+
+	if desired.Power != nil && *desired.Power == "standby" && dev.Policy.DisableStandby {
+		return fmt.Errorf("%w: %s can't be put in standby", ErrPolicy, dev.ID)
+	}
+
+	if desired.Volume != nil && dev.Policy.MaxVolume > 0 && *desired.Volume > dev.Policy.MaxVolume {
+		return fmt.Errorf("%w: %s's volume can't be set above %d", ErrPolicy, dev.ID, dev.Policy.MaxVolume)
+	}
+
+	return nil
+}
+
+// standbyKeys are the remote keys (lowercased) that can put a device in standby
+var standbyKeys = map[string]bool{
+	"power":      true,
+	"poweroff":   true,
+	"tvpower":    true,
+	"tvstandby":  true,
+	"sleep":      true,
+	"sleeptimer": true,
+}
+
+// remoteKeyState is the state pressing key could put the device in, to check against its policy
+func remoteKeyState(key string) DesiredState {
+	if standbyKeys[strings.ToLower(key)] {
+		standby := "standby"
+		return DesiredState{Power: &standby}
+	}
+
+	return DesiredState{}
+}
+
+// GetDevices lists the devices in the inventory. Their keys aren't included
+func (d *DeviceManager) GetDevices(context *gin.Context) {
+	devices := helpers.Inventory.Devices()
+	if devices == nil {
+		devices = []inventory.Device{}
+	}
+
+	for i := range devices {
+		devices[i].PSK = ""
+	}
+
+	context.JSON(http.StatusOK, Response{Data: devices})
+}
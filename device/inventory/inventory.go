@@ -0,0 +1,185 @@
+// Package inventory maps names for our TVs, like ITB-1101-D1, to their address and everything
+// else we know about them, so that room configs don't have to embed ip addresses
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Device is a single TV in the inventory
+type Device struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+
+	// PSK overrides the key from the credential store for this device
+	PSK string `json:"psk,omitempty"`
+
+	// Room is the room the device is in. It defaults to the id without its last part, e.g. ITB-1101 for ITB-1101-D1
+	Room string `json:"room,omitempty"`
+
+	// Family is the model family, e.g. "bravia-2018"
+	Family string `json:"family,omitempty"`
+
+	// MAC is the device's MAC address, for waking it up before we've learned it from the device
+	MAC string `json:"mac,omitempty"`
+
+	// Inputs maps friendly names, e.g. "laptop", to the port they're plugged into, e.g. "hdmi!2"
+	Inputs map[string]string `json:"inputs,omitempty"`
+
+	Policy Policy `json:"policy"`
+}
+
+// Policy limits what can be done to a device
+type Policy struct {
+	// MaxVolume is the highest volume the device can be set to. 0 means no limit
+	MaxVolume int `json:"maxVolume,omitempty"`
+
+	// DisableStandby keeps the device from being turned off, e.g. for a lobby display
+	DisableStandby bool `json:"disableStandby,omitempty"`
+}
+
+// Inventory is the set of devices loaded from an inventory file:
+//
+//	{"devices": {"ITB-1101-D1": {"address": "10.5.34.12", "family": "bravia-2018", "inputs": {"laptop": "hdmi!2"}, "policy": {"maxVolume": 80}}}}
+//
+// Device ids are case insensitive. The zero value (and nil) is an empty inventory
+type Inventory struct {
+	path string
+
+	mu        sync.RWMutex
+	devices   map[string]Device
+	addresses map[string]string
+}
+
+type inventoryFile struct {
+	Devices map[string]Device `json:"devices"`
+}
+
+// Load builds an inventory from the file at path
+func Load(path string) (*Inventory, error) {
+	i := &Inventory{
+		path: path,
+	}
+
+	if err := i.Reload(); err != nil {
+		return nil, err
+	}
+
+	return i, nil
+}
+
+// Reload rereads the inventory's file. The current devices are kept if it can't be read
+func (i *Inventory) Reload() error {
+	if i == nil || i.path == "" {
+		return nil
+	}
+
+	b, err := os.ReadFile(i.path)
+	if err != nil {
+		return fmt.Errorf("unable to read inventory: %w", err)
+	}
+
+	var file inventoryFile
+	if err := json.Unmarshal(b, &file); err != nil {
+		return fmt.Errorf("unable to parse inventory: %w", err)
+	}
+
+	devices := make(map[string]Device, len(file.Devices))
+	addresses := make(map[string]string, len(file.Devices))
+
+	for id, dev := range file.Devices {
+		if dev.Address == "" {
+			return fmt.Errorf("unable to parse inventory: %s has no address", id)
+		}
+
+		dev.ID = id
+		if dev.Room == "" {
+			if i := strings.LastIndex(id, "-"); i > 0 {
+				dev.Room = id[:i]
+			}
+		}
+
+		devices[strings.ToLower(id)] = dev
+		addresses[strings.ToLower(dev.Address)] = strings.ToLower(id)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.devices = devices
+	i.addresses = addresses
+
+	return nil
+}
+
+// Lookup finds a device by its id or its address
+func (i *Inventory) Lookup(idOrAddress string) (Device, bool) {
+	if i == nil {
+		return Device{}, false
+	}
+
+	key := strings.ToLower(idOrAddress)
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	if dev, ok := i.devices[key]; ok {
+		return dev, true
+	}
+
+	if id, ok := i.addresses[key]; ok {
+		return i.devices[id], true
+	}
+
+	return Device{}, false
+}
+
+// Devices returns every device in the inventory, sorted by id
+func (i *Inventory) Devices() []Device {
+	if i == nil {
+		return nil
+	}
+
+	i.mu.RLock()
+	devices := make([]Device, 0, len(i.devices))
+	for _, dev := range i.devices {
+		devices = append(devices, dev)
+	}
+	i.mu.RUnlock()
+
+	sort.Slice(devices, func(a, b int) bool {
+		return devices[a].ID < devices[b].ID
+	})
+
+	return devices
+}
+
+// Port returns the port an input alias is plugged into, e.g. "hdmi!2" for "laptop". Aliases are case
+// insensitive, and anything that isn't an alias is returned as is
+func (d Device) Port(input string) string {
+	for alias, port := range d.Inputs {
+		if strings.EqualFold(alias, input) {
+			return port
+		}
+	}
+
+	return input
+}
+
+// Alias returns the alias for port, or "" if it doesn't have one. If a port has several aliases,
+// the first one alphabetically is returned
+func (d Device) Alias(port string) string {
+	var found string
+	for alias, p := range d.Inputs {
+		if strings.EqualFold(p, port) && (found == "" || alias < found) {
+			found = alias
+		}
+	}
+
+	return found
+}
@@ -0,0 +1,292 @@
+package device
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/byuoitav/sony-control-microservice/device/audit"
+	"github.com/byuoitav/sony-control-microservice/device/helpers"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Job statuses
+const (
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// jobRetention is how long a finished job can still be looked up
+const jobRetention = 15 * time.Minute
+
+// ErrShuttingDown is returned when a job is started after we've started shutting down
+var ErrShuttingDown = errors.New("shutting down")
+
+// Job is an action running in the background, started by a request with ?async=true
+type Job struct {
+	ID        string `json:"id"`
+	RequestID string `json:"requestId,omitempty"`
+	Address   string `json:"address"`
+	Device    string `json:"device,omitempty"`
+	Action    string `json:"action"`
+	Target    string `json:"target"`
+	Status    string `json:"status"`
+
+	// Power is the last power status the device reported while the job was running
+	Power string `json:"power,omitempty"`
+
+	Started  time.Time      `json:"started"`
+	Finished *time.Time     `json:"finished,omitempty"`
+	Error    *ErrorResponse `json:"error,omitempty"`
+}
+
+// jobFunc is the work a job does. It can update the job as it goes
+type jobFunc func(ctx context.Context, update func(func(job *Job))) error
+
+// jobStore keeps track of running jobs, and of finished ones for jobRetention
+type jobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+
+	// ctx is given to every job, and is only cancelled if jobs are still running when we shut down
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+
+	// closed is set once we start waiting for running jobs, after which no new ones can start
+	closed bool
+}
+
+// add stores job and returns the context it should run with. s.running is incremented for it
+func (s *jobStore) add(job *Job) (context.Context, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, fmt.Errorf("%w: not starting a new job", ErrShuttingDown)
+	}
+
+	if s.jobs == nil {
+		s.jobs = make(map[string]*Job)
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+	}
+
+	for id, j := range s.jobs {
+		if j.Finished != nil && time.Since(*j.Finished) > jobRetention {
+			delete(s.jobs, id)
+		}
+	}
+
+	s.jobs[job.ID] = job
+	s.running.Add(1)
+
+	return s.ctx, nil
+}
+
+// close stops new jobs from being started, so that it's safe to wait for the running ones
+func (s *jobStore) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+}
+
+// update changes the job with id while holding the lock
+func (s *jobStore) update(id string, f func(job *Job)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if job, ok := s.jobs[id]; ok {
+		f(job)
+	}
+}
+
+// get returns a copy of the job with id
+func (s *jobStore) get(id string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+
+	return *job, true
+}
+
+// wait waits for every running job to finish, or for ctx to be done
+func (s *jobStore) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// cancelAll cancels every running job
+func (s *jobStore) cancelAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
+
+// startJob runs fn in the background and returns the job tracking it. The job is marked as succeeded
+// or failed depending on what fn returns, and how it finished is written to the audit log
+func (d *DeviceManager) startJob(context *gin.Context, job Job, fn jobFunc) (Job, error) {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+
+	job.ID = hex.EncodeToString(buf)
+	job.RequestID = requestID(context)
+	job.Status = JobRunning
+	job.Started = time.Now()
+
+	// the request is done by the time the job finishes, so take what the audit record needs from it now
+	record := audit.Record{
+		RequestID: job.RequestID,
+		SourceIP:  context.ClientIP(),
+		Device:    job.Device,
+		Address:   job.Address,
+		Action:    "job " + job.Action,
+		Params:    map[string]string{"job": job.ID, "target": job.Target},
+	}
+
+	if id, ok := identity(context); ok {
+		record.Caller = id.Name
+		record.Auth = id.Method
+	}
+
+	stored := job
+	ctx, err := d.jobs.add(&stored)
+	if err != nil {
+		return Job{}, err
+	}
+
+	update := func(f func(job *Job)) {
+		d.jobs.update(job.ID, f)
+	}
+
+	go func() {
+		defer d.jobs.running.Done()
+
+		err := fn(ctx, update)
+		if err != nil {
+			d.Log.Error("Job failed", zap.String("job", job.ID), zap.String("address", job.Address), zap.String("action", job.Action), zap.Error(err))
+		}
+
+		record.Time = time.Now()
+		record.Status = http.StatusOK
+		record.LatencyMS = float64(time.Since(job.Started).Microseconds()) / 1000
+
+		update(func(job *Job) {
+			job.Finished = &record.Time
+			job.Status = JobSucceeded
+
+			if err != nil {
+				code, resp := classifyError(err)
+				resp.Address = job.Address
+				resp.RequestID = job.RequestID
+
+				job.Status = JobFailed
+				job.Error = &resp
+
+				record.Status = code
+				record.ErrorCode = resp.Code
+				record.Error = resp.Message
+			}
+
+			record.Params["status"] = job.Status
+			record.Params["power"] = job.Power
+		})
+
+		if err := d.Audit.Write(record); err != nil {
+			d.Log.Error("unable to write audit record", zap.Any("record", record), zap.Error(err))
+		}
+	}()
+
+	return job, nil
+}
+
+// isAsync returns true if the request asked to run in the background with ?async=true
+func isAsync(context *gin.Context) bool {
+	async, _ := strconv.ParseBool(context.Query("async"))
+	return async
+}
+
+// startPowerJob checks the device's policy, then turns it on or to standby in the background
+func (d *DeviceManager) startPowerJob(context *gin.Context, address string, on bool) (Job, error) {
+	power := "standby"
+	if on {
+		power = "on"
+	}
+
+	if err := checkPolicy(address, DesiredState{Power: &power}); err != nil {
+		return Job{}, err
+	}
+
+	dev, _ := helpers.Inventory.Lookup(address)
+	job := Job{
+		Address: address,
+		Device:  dev.ID,
+		Action:  "power",
+		Target:  power,
+	}
+
+	return d.startJob(context, job, d.powerJob(address, on))
+}
+
+func (d *DeviceManager) powerJob(address string, on bool) jobFunc {
+	return func(ctx context.Context, update func(func(job *Job))) error {
+		return d.changePower(ctx, address, on, func(power string) {
+			update(func(job *Job) {
+				job.Power = power
+			})
+		})
+	}
+}
+
+// getJob returns the job in :id, if the caller is allowed to see the device it's for
+func (d *DeviceManager) getJob(context *gin.Context) (Job, error) {
+	job, ok := d.jobs.get(context.Param("id"))
+	if ok {
+		if id, authenticated := identity(context); authenticated {
+			dev, _ := helpers.Inventory.Lookup(job.Address)
+			ok = id.CanAccess(dev.ID, dev.Room, job.Address)
+		}
+	}
+
+	if !ok {
+		return Job{}, fmt.Errorf("%w: no such job %s", ErrNotFound, context.Param("id"))
+	}
+
+	return job, nil
+}
+
+// GetJob returns the progress of a job started with ?async=true
+func (d *DeviceManager) GetJob(context *gin.Context) {
+	job, err := d.getJob(context)
+	if err != nil {
+		d.respondError(context, "Failed to get job", err)
+		return
+	}
+
+	context.JSON(http.StatusOK, job)
+}
@@ -0,0 +1,284 @@
+// Package notify subscribes to the notifications Sony TVs push over websockets and
+// normalizes them into state change events.
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/byuoitav/sony-control-microservice/device/helpers"
+	"github.com/byuoitav/sony-control-microservice/device/scalar"
+	"go.uber.org/zap"
+)
+
+// Event types
+const (
+	EventPower      = "power"
+	EventInput      = "input"
+	EventVolume     = "volume"
+	EventMute       = "mute"
+	EventConnection = "connection"
+)
+
+// Event is a change in a TV's state
+type Event struct {
+	Address string      `json:"address"`
+	Type    string      `json:"type"`
+	Value   interface{} `json:"value"`
+	Time    time.Time   `json:"time"`
+}
+
+// Connection is the value of an EventConnection event
+type Connection struct {
+	Service   string `json:"service"`
+	Connected bool   `json:"connected"`
+	Error     string `json:"error,omitempty"`
+}
+
+// notifications are the notifications we enable on each service
+var notifications = map[string][]string{
+	"system":    {scalar.NotifyPowerStatus},
+	"audio":     {scalar.NotifyVolumeInformation},
+	"avContent": {scalar.NotifyPlayingContentInfo},
+}
+
+const (
+	minBackoff = 1 * time.Second
+	maxBackoff = 30 * time.Second
+
+	// subscriberBuffer is how many events a slow subscriber can fall behind before events are dropped
+	subscriberBuffer = 32
+)
+
+// Hub keeps a notification subscription open to each TV that has at least one subscriber
+// and fans its events out to every subscriber
+type Hub struct {
+	Client *scalar.Client
+	Log    *zap.Logger
+
+	mu      sync.Mutex
+	devices map[string]*device
+	closed  bool
+}
+
+type device struct {
+	cancel      context.CancelFunc
+	subscribers map[chan Event]struct{}
+}
+
+// NewHub returns a hub that subscribes to TVs using client
+func NewHub(client *scalar.Client, log *zap.Logger) *Hub {
+	return &Hub{
+		Client:  client,
+		Log:     log,
+		devices: make(map[string]*device),
+	}
+}
+
+// Subscribe returns a channel of events from the TV at address and a func that must be
+// called to unsubscribe. The channel is closed after unsubscribing or closing the hub, and
+// is already closed if the hub has been
+func (h *Hub) Subscribe(address string) (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// don't start watching a TV while we're shutting down
+	if h.closed {
+		close(ch)
+		return ch, func() {}
+	}
+
+	dev, ok := h.devices[address]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		dev = &device{
+			cancel:      cancel,
+			subscribers: make(map[chan Event]struct{}),
+		}
+
+		h.devices[address] = dev
+		for service, names := range notifications {
+			go h.watch(ctx, address, service, names)
+		}
+
+		h.Log.Info("subscribed to notifications", zap.String("address", address))
+	}
+
+	dev.subscribers[ch] = struct{}{}
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.unsubscribe(address, ch)
+		})
+	}
+}
+
+func (h *Hub) unsubscribe(address string, ch chan Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	dev, ok := h.devices[address]
+	if !ok {
+		return
+	}
+
+	if _, ok := dev.subscribers[ch]; !ok {
+		return
+	}
+
+	delete(dev.subscribers, ch)
+	close(ch)
+
+	if len(dev.subscribers) == 0 {
+		dev.cancel()
+		delete(h.devices, address)
+
+		h.Log.Info("unsubscribed from notifications", zap.String("address", address))
+	}
+}
+
+// Close drops every subscription and closes every subscriber's channel. Subscribing after
+// the hub is closed doesn't subscribe to anything
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+
+	for address, dev := range h.devices {
+		dev.cancel()
+		for ch := range dev.subscribers {
+			close(ch)
+		}
+
+		delete(h.devices, address)
+	}
+}
+
+func (h *Hub) publish(event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	dev, ok := h.devices[event.Address]
+	if !ok {
+		return
+	}
+
+	for ch := range dev.subscribers {
+		select {
+		case ch <- event:
+		default:
+			h.Log.Debug("dropping event for slow subscriber", zap.String("address", event.Address), zap.String("type", event.Type))
+		}
+	}
+}
+
+// watch keeps a notification websocket open to service until ctx is cancelled
+func (h *Hub) watch(ctx context.Context, address, service string, names []string) {
+	log := h.Log.With(zap.String("address", address), zap.String("service", service))
+	backoff := minBackoff
+
+	for ctx.Err() == nil {
+		conn, err := h.Client.DialNotifications(ctx, address, service, names...)
+		if err != nil {
+			log.Warn("unable to subscribe to notifications", zap.Error(err), zap.Duration("retryIn", backoff))
+			h.publish(newEvent(address, EventConnection, Connection{Service: service, Error: err.Error()}))
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+
+			backoff = min(backoff*2, maxBackoff)
+			continue
+		}
+
+		backoff = minBackoff
+		h.publish(newEvent(address, EventConnection, Connection{Service: service, Connected: true}))
+
+		stop := context.AfterFunc(ctx, func() {
+			conn.Close()
+		})
+
+		for {
+			notification, err := conn.Next()
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Warn("lost notification connection", zap.Error(err))
+					h.publish(newEvent(address, EventConnection, Connection{Service: service, Error: err.Error()}))
+				}
+
+				break
+			}
+
+			for _, event := range normalize(address, notification) {
+				h.publish(event)
+			}
+		}
+
+		stop()
+		conn.Close()
+	}
+}
+
+func newEvent(address, typ string, value interface{}) Event {
+	return Event{
+		Address: address,
+		Type:    typ,
+		Value:   value,
+		Time:    time.Now(),
+	}
+}
+
+// normalize converts a notification from the TV into our events
+func normalize(address string, n scalar.Notification) []Event {
+	if len(n.Params) == 0 {
+		return nil
+	}
+
+	switch n.Method {
+	case scalar.NotifyPowerStatus:
+		var power scalar.PowerStatus
+		if err := json.Unmarshal(n.Params[0], &power); err != nil {
+			return nil
+		}
+
+		value := power.Status
+		if value == "active" {
+			value = "on"
+		}
+
+		return []Event{newEvent(address, EventPower, value)}
+	case scalar.NotifyPlayingContentInfo:
+		var content scalar.PlayingContentInfo
+		if err := json.Unmarshal(n.Params[0], &content); err != nil {
+			return nil
+		}
+
+		// anything that isn't an external input (e.g. an app) is reported by its uri
+		value := content.URI
+		if port, ok := helpers.ParsePort(content.URI); ok {
+			value = port
+		}
+
+		return []Event{newEvent(address, EventInput, value)}
+	case scalar.NotifyVolumeInformation:
+		var volume scalar.VolumeInformation
+		if err := json.Unmarshal(n.Params[0], &volume); err != nil || volume.Target != "speaker" {
+			return nil
+		}
+
+		return []Event{
+			newEvent(address, EventVolume, volume.Volume),
+			newEvent(address, EventMute, volume.Mute),
+		}
+	}
+
+	return nil
+}
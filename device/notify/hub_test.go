@@ -0,0 +1,27 @@
+package notify
+
+import (
+	"testing"
+
+	"github.com/byuoitav/sony-control-microservice/device/scalar"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestSubscribeAfterClose(t *testing.T) {
+	h := NewHub(&scalar.Client{}, zaptest.NewLogger(t))
+	h.Close()
+
+	events, unsubscribe := h.Subscribe("10.0.0.1")
+	defer unsubscribe()
+
+	if _, ok := <-events; ok {
+		t.Fatal("got an event from a closed hub")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.devices) != 0 {
+		t.Fatalf("a closed hub started watching %d devices", len(h.devices))
+	}
+}
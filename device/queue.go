@@ -0,0 +1,150 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrQueueFull is returned when a device already has QueueDepth actions waiting
+	ErrQueueFull = errors.New("too many actions queued for device")
+
+	// ErrQueueTimeout is returned when an action waits longer than QueueTimeout for its turn
+	ErrQueueTimeout = errors.New("timed out waiting for actions queued before it")
+)
+
+const (
+	// DefaultQueueDepth is used when DeviceManager.QueueDepth isn't set
+	DefaultQueueDepth = 16
+
+	// DefaultQueueTimeout is used when DeviceManager.QueueTimeout isn't set
+	DefaultQueueTimeout = time.Minute
+
+	// workerIdleTimeout is how long a device's worker waits for another action before exiting
+	workerIdleTimeout = time.Minute
+)
+
+// Action states. An action is queued until the worker starts it, or until its caller gives up
+const (
+	actionQueued int32 = iota
+	actionRunning
+	actionAbandoned
+)
+
+type action struct {
+	fn    func() error
+	done  chan error
+	state atomic.Int32
+}
+
+// worker runs the actions for a single device, one at a time, in the order they were queued
+type worker struct {
+	address string
+	actions chan *action
+}
+
+// enqueue runs fn after every action already queued for address has finished, and returns its error.
+// fn is skipped if ctx is done, or QueueTimeout passes, before its turn, and should stop promptly if ctx
+// is done while it's running. Status reads don't go through the queue, so they can still run while
+// actions are in flight
+func (d *DeviceManager) enqueue(ctx context.Context, address string, fn func() error) error {
+	a := &action{
+		fn:   fn,
+		done: make(chan error, 1),
+	}
+
+	d.workersMu.Lock()
+	if d.workers == nil {
+		d.workers = make(map[string]*worker)
+	}
+
+	w, ok := d.workers[address]
+	if !ok {
+		depth := d.QueueDepth
+		if depth <= 0 {
+			depth = DefaultQueueDepth
+		}
+
+		w = &worker{
+			address: address,
+			actions: make(chan *action, depth),
+		}
+
+		d.workers[address] = w
+		go d.runWorker(w)
+	}
+
+	select {
+	case w.actions <- a:
+	default:
+		d.workersMu.Unlock()
+		return fmt.Errorf("%w %s (%d waiting)", ErrQueueFull, address, cap(w.actions))
+	}
+	d.workersMu.Unlock()
+
+	// a request without a deadline (e.g. a power change) could otherwise wait forever behind a stuck device
+	timeout := withDefault(d.QueueTimeout, DefaultQueueTimeout)
+	wait := time.NewTimer(timeout)
+	defer wait.Stop()
+
+	var err error
+	select {
+	case err := <-a.done:
+		return err
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-wait.C:
+		err = fmt.Errorf("%w on %s after %s", ErrQueueTimeout, address, timeout)
+	}
+
+	if a.state.CompareAndSwap(actionQueued, actionAbandoned) {
+		return err
+	}
+
+	// fn is already running with ctx, so wait for it to stop rather than letting it outlive the request.
+	// Only its time in the queue is limited by QueueTimeout
+	return <-a.done
+}
+
+func (d *DeviceManager) runWorker(w *worker) {
+	idle := time.NewTimer(workerIdleTimeout)
+	defer idle.Stop()
+
+	for {
+		select {
+		case a := <-w.actions:
+			// don't bother with actions whose caller has already given up
+			if a.state.CompareAndSwap(actionQueued, actionRunning) {
+				a.done <- a.fn()
+			}
+
+			if !idle.Stop() {
+				select {
+				case <-idle.C:
+				default:
+				}
+			}
+			idle.Reset(workerIdleTimeout)
+		case <-idle.C:
+			// actions are only queued while holding workersMu, so we can safely exit
+			// as long as nothing was queued before we got it
+			d.workersMu.Lock()
+			if len(w.actions) > 0 {
+				d.workersMu.Unlock()
+				idle.Reset(workerIdleTimeout)
+				continue
+			}
+
+			delete(d.workers, w.address)
+			d.workersMu.Unlock()
+
+			d.Log.Debug("stopping idle worker", zap.String("address", w.address))
+			return
+		}
+	}
+}
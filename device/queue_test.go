@@ -0,0 +1,160 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zaptest"
+)
+
+const queueAddress = "10.0.0.1"
+
+// blockQueue runs an action on address that doesn't finish until the returned func is called. It
+// returns once the action is running, and the action's error is sent on done
+func blockQueue(t *testing.T, d *DeviceManager) (release func(), done <-chan error) {
+	t.Helper()
+
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	errs := make(chan error, 1)
+
+	go func() {
+		errs <- d.enqueue(context.Background(), queueAddress, func() error {
+			close(started)
+			<-unblock
+			return nil
+		})
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocking action never started")
+	}
+
+	return func() { close(unblock) }, errs
+}
+
+// waitQueued waits until n actions are waiting in address's queue
+func waitQueued(t *testing.T, d *DeviceManager, n int) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		d.workersMu.Lock()
+		queued := len(d.workers[queueAddress].actions)
+		d.workersMu.Unlock()
+
+		if queued == n {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("%d actions are queued, want %d", queued, n)
+		}
+	}
+}
+
+func TestQueueOrder(t *testing.T) {
+	d := &DeviceManager{Log: zaptest.NewLogger(t)}
+	release, _ := blockQueue(t, d)
+
+	var mu sync.Mutex
+	var order []int
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			err := d.enqueue(context.Background(), queueAddress, func() error {
+				mu.Lock()
+				order = append(order, i)
+				mu.Unlock()
+				return nil
+			})
+			if err != nil {
+				t.Errorf("action %d failed: %s", i, err)
+			}
+		}(i)
+
+		// queue them one at a time, so that we know what order they arrived in
+		waitQueued(t, d, i+1)
+	}
+
+	release()
+	wg.Wait()
+
+	for i := range order {
+		if order[i] != i {
+			t.Fatalf("actions ran in order %v, want the order they were queued in", order)
+		}
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	d := &DeviceManager{Log: zaptest.NewLogger(t), QueueDepth: 2}
+	release, _ := blockQueue(t, d)
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			errs <- d.enqueue(context.Background(), queueAddress, func() error { return nil })
+		}()
+	}
+
+	waitQueued(t, d, 2)
+
+	ran := false
+	err := d.enqueue(context.Background(), queueAddress, func() error {
+		ran = true
+		return nil
+	})
+
+	if !errors.Is(err, ErrQueueFull) || ran {
+		t.Fatalf("got %v (ran: %v) with a full queue, want %s", err, ran, ErrQueueFull)
+	}
+
+	release()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("queued action failed: %s", err)
+		}
+	}
+}
+
+func TestQueueTimeout(t *testing.T) {
+	d := &DeviceManager{Log: zaptest.NewLogger(t), QueueTimeout: 50 * time.Millisecond}
+	release, done := blockQueue(t, d)
+
+	ran := make(chan struct{}, 1)
+	err := d.enqueue(context.Background(), queueAddress, func() error {
+		ran <- struct{}{}
+		return nil
+	})
+
+	if !errors.Is(err, ErrQueueTimeout) {
+		t.Fatalf("got %v after waiting behind a stuck action, want %s", err, ErrQueueTimeout)
+	}
+
+	// the running action isn't cut off by the timeout, and the one that gave up never runs
+	time.Sleep(100 * time.Millisecond)
+	release()
+
+	if err := <-done; err != nil {
+		t.Fatalf("running action failed: %s", err)
+	}
+
+	if err := d.enqueue(context.Background(), queueAddress, func() error { return nil }); err != nil {
+		t.Fatalf("action after the stuck one failed: %s", err)
+	}
+
+	select {
+	case <-ran:
+		t.Fatal("an action ran after its caller timed out waiting for it")
+	default:
+	}
+}
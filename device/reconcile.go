@@ -0,0 +1,315 @@
+package device
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Outcomes of reconciling a single field
+const (
+	OutcomeUnchanged = "unchanged"
+	OutcomeChanged   = "changed"
+	OutcomeSkipped   = "skipped"
+	OutcomeFailed    = "failed"
+)
+
+// DesiredState is the state a device should be put into. Fields that are left out aren't changed
+type DesiredState struct {
+	Power   *string `json:"power,omitempty"`
+	Input   *string `json:"input,omitempty"`
+	Volume  *int    `json:"volume,omitempty"`
+	Muted   *bool   `json:"muted,omitempty"`
+	Blanked *bool   `json:"blanked,omitempty"`
+}
+
+func (s DesiredState) validate() error {
+	if s.Power != nil && *s.Power != "on" && *s.Power != "standby" {
+		return invalidRequest("power must be \"on\" or \"standby\", not %q", *s.Power)
+	}
+
+	if s.Input != nil && !strings.Contains(*s.Input, "!") {
+		return invalidRequest("input should be an alias or follow the format \"hdmi!2\", not %q", *s.Input)
+	}
+
+	if s.Volume != nil && (*s.Volume < 0 || *s.Volume > 100) {
+		return invalidRequest("volume must be a value from 0 to 100, not %d", *s.Volume)
+	}
+
+	return nil
+}
+
+// require makes sure field was set in the request
+func (s DesiredState) require(field string) error {
+	var ok bool
+	switch field {
+	case "power":
+		ok = s.Power != nil
+	case "input":
+		ok = s.Input != nil
+	case "volume":
+		ok = s.Volume != nil
+	case "muted":
+		ok = s.Muted != nil
+	case "blanked":
+		ok = s.Blanked != nil
+	}
+
+	if !ok {
+		return invalidRequest("%s is required", field)
+	}
+
+	return nil
+}
+
+// Outcome is what happened to a single field while reconciling
+type Outcome struct {
+	Status string         `json:"status"`
+	From   interface{}    `json:"from,omitempty"`
+	To     interface{}    `json:"to"`
+	Reason string         `json:"reason,omitempty"`
+	Error  *ErrorResponse `json:"error,omitempty"`
+
+	// Verified is set for changed fields, to whether the device reported the change when it was read back
+	Verified *bool `json:"verified,omitempty"`
+
+	err error
+}
+
+// Reconciliation is the outcome of every field in a DesiredState
+type Reconciliation map[string]*Outcome
+
+// firstError returns the error of the first field that failed, in the order fields are applied
+func (r Reconciliation) firstError() error {
+	for _, field := range []string{"power", "input", "volume", "muted", "blanked"} {
+		if outcome, ok := r[field]; ok && outcome.Status == OutcomeFailed {
+			return outcome.err
+		}
+	}
+
+	return nil
+}
+
+// apply sets a field to the desired value if it isn't already, recording what happened. write returns
+// whether the change was verified; a change that wasn't is still recorded as changed
+func apply[T comparable](r Reconciliation, field string, to T, read func() (T, error), write func() (bool, error)) bool {
+	outcome := &Outcome{To: to}
+	r[field] = outcome
+
+	from, err := read()
+	if err != nil {
+		outcome.fail(err)
+		return false
+	}
+
+	outcome.From = from
+	if from == to {
+		outcome.Status = OutcomeUnchanged
+		return true
+	}
+
+	verified, err := write()
+	if err := allowUnverified(err); err != nil {
+		outcome.fail(err)
+		return false
+	}
+
+	outcome.Status = OutcomeChanged
+	outcome.Verified = &verified
+	return true
+}
+
+func (o *Outcome) fail(err error) {
+	_, resp := classifyError(err)
+
+	o.Status = OutcomeFailed
+	o.Error = &resp
+	o.err = err
+}
+
+func (o *Outcome) skip(reason string) {
+	o.Status = OutcomeSkipped
+	o.Reason = reason
+}
+
+// reconcile issues only the calls needed to put the device into the desired state. Power is
+// changed (and waited on) first, then input, volume, mute and blanking
+func (d *DeviceManager) reconcile(ctx context.Context, address string, desired DesiredState) Reconciliation {
+	r := make(Reconciliation)
+	defer d.cache.invalidate(address, allFields...)
+
+	// read power fresh, because we can't trust a cached value to decide what to do
+	power, err := d.readPower(ctx, address, true)
+	if err != nil {
+		r.skipAll(desired, "unable to get power status")
+		if desired.Power != nil {
+			r["power"] = &Outcome{To: *desired.Power}
+			r["power"].fail(err)
+		}
+
+		return r
+	}
+
+	if desired.Power != nil {
+		ok := apply(r, "power", *desired.Power, func() (string, error) {
+			return power.Power, nil
+		}, func() (bool, error) {
+			// setPower waits until the tv reports the new power status
+			return true, d.setPower(ctx, address, *desired.Power == "on", nil)
+		})
+
+		if !ok {
+			r.skipAll(desired, "unable to set power")
+			return r
+		}
+
+		power.Power = *desired.Power
+	}
+
+	if power.Power != "on" {
+		r.skipAll(desired, "tv is in standby")
+		return r
+	}
+
+	if desired.Input != nil {
+		apply(r, "input", *desired.Input, func() (string, error) {
+			input, err := d.readInput(ctx, address, true)
+			return input.Input, err
+		}, func() (bool, error) {
+			return d.setInput(ctx, address, *desired.Input)
+		})
+	}
+
+	// volume and mute come from the same call, so only read it once
+	var audio audioState
+	var audioErr error
+	var audioRead bool
+	readAudio := func() error {
+		if !audioRead {
+			audio, audioErr = d.readAudio(ctx, address, true)
+			audioRead = true
+		}
+
+		return audioErr
+	}
+
+	if desired.Volume != nil {
+		apply(r, "volume", *desired.Volume, func() (int, error) {
+			err := readAudio()
+			return audio.Volume.Volume, err
+		}, func() (bool, error) {
+			return d.setVolume(ctx, address, *desired.Volume)
+		})
+	}
+
+	if desired.Muted != nil {
+		apply(r, "muted", *desired.Muted, func() (bool, error) {
+			err := readAudio()
+			return audio.Mute.Muted, err
+		}, func() (bool, error) {
+			return d.setMute(ctx, address, *desired.Muted)
+		})
+	}
+
+	if desired.Blanked != nil {
+		apply(r, "blanked", *desired.Blanked, func() (bool, error) {
+			blanked, err := d.readBlanked(ctx, address, true)
+			return blanked.Blanked, err
+		}, func() (bool, error) {
+			return d.setBlanked(ctx, address, *desired.Blanked)
+		})
+	}
+
+	return r
+}
+
+// skipAll marks every desired field other than power as skipped
+func (r Reconciliation) skipAll(desired DesiredState, reason string) {
+	skip := func(field string, to interface{}) {
+		outcome := &Outcome{To: to}
+		outcome.skip(reason)
+		r[field] = outcome
+	}
+
+	if desired.Input != nil {
+		skip("input", *desired.Input)
+	}
+
+	if desired.Volume != nil {
+		skip("volume", *desired.Volume)
+	}
+
+	if desired.Muted != nil {
+		skip("muted", *desired.Muted)
+	}
+
+	if desired.Blanked != nil {
+		skip("blanked", *desired.Blanked)
+	}
+}
+
+// SetState puts the device into the desired state in the body, issuing only the calls that are
+// needed, and reports what happened to each field
+func (d *DeviceManager) SetState(context *gin.Context) {
+	if r, ok := d.putState(context); ok {
+		context.JSON(http.StatusOK, r)
+	}
+}
+
+// putState handles a request to reconcile the device with the body. If any field failed, the error
+// has already been written to the client, along with every field's outcome
+func (d *DeviceManager) putState(context *gin.Context) (Reconciliation, bool) {
+	address := context.Param("address")
+
+	var desired DesiredState
+	if err := context.ShouldBindJSON(&desired); err != nil {
+		d.respondError(context, "Invalid desired state", invalidRequest("%s", err))
+		return nil, false
+	}
+
+	desired.resolveAliases(address)
+
+	if err := desired.validate(); err != nil {
+		d.respondError(context, "Invalid desired state", err)
+		return nil, false
+	}
+
+	r, err := d.applyState(context.Request.Context(), address, desired)
+	if r == nil {
+		d.respondError(context, "Failed to reconcile state", err)
+		return nil, false
+	}
+
+	if err != nil {
+		code, resp := errorResponse(context, err)
+		d.Log.Warn("Failed to reconcile state", zap.String("address", address), zap.String("requestID", resp.RequestID), zap.Error(err))
+		writeError(context, code, resp, r)
+		return nil, false
+	}
+
+	return r, true
+}
+
+// applyState reconciles the device with desired as a single queued action. If the action couldn't
+// run, the Reconciliation is nil; otherwise err is the error of the first field that failed
+func (d *DeviceManager) applyState(ctx context.Context, address string, desired DesiredState) (Reconciliation, error) {
+	d.Log.Debug("Reconciling state", zap.String("address", address), zap.Any("desired", desired))
+
+	if err := checkPolicy(address, desired); err != nil {
+		return nil, err
+	}
+
+	var r Reconciliation
+	err := d.enqueue(ctx, address, func() error {
+		r = d.reconcile(ctx, address, desired)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return r, r.firstError()
+}
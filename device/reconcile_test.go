@@ -0,0 +1,157 @@
+package device_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/byuoitav/sony-control-microservice/device"
+	"github.com/byuoitav/sony-control-microservice/simulator"
+)
+
+// putState sends desired to PUT /:address/state, returning the status code, every field's outcome and
+// the error, if there was one
+func (s *testService) putState(desired string) (int, map[string]device.Outcome, *device.ErrorResponse) {
+	s.t.Helper()
+
+	var raw json.RawMessage
+	code := s.do(http.MethodPut, "/:address/state", desired, &raw)
+
+	// a failure's outcomes are in the error envelope's data
+	var resp struct {
+		Data  map[string]device.Outcome
+		Error *device.ErrorResponse
+	}
+
+	if code == http.StatusOK {
+		if err := json.Unmarshal(raw, &resp.Data); err != nil {
+			s.t.Fatalf("unable to decode outcomes (%s): %s", raw, err)
+		}
+	} else if err := json.Unmarshal(raw, &resp); err != nil {
+		s.t.Fatalf("unable to decode error (%s): %s", raw, err)
+	}
+
+	return code, resp.Data, resp.Error
+}
+
+// expectOutcome fails the test unless field's outcome has the given status
+func expectOutcome(t *testing.T, outcomes map[string]device.Outcome, field, status string) device.Outcome {
+	t.Helper()
+
+	outcome, ok := outcomes[field]
+	if !ok {
+		t.Fatalf("no outcome for %s in %+v", field, outcomes)
+	}
+
+	if outcome.Status != status {
+		t.Fatalf("%s was %s (%+v), want %s", field, outcome.Status, outcome, status)
+	}
+
+	return outcome
+}
+
+func TestReconcilePowersOnFirst(t *testing.T) {
+	s := newTestService(t, nil)
+
+	state := s.tv.State()
+	state.Power = false
+	s.tv.SetState(state)
+
+	// the tv rejects input and audio calls until it has finished turning on, so this only works if
+	// power is changed and waited on first
+	s.tv.SetFaults(simulator.Faults{PowerOnDelay: simulator.Duration(300 * time.Millisecond), DisplayOffErrors: true})
+
+	code, outcomes, _ := s.putState(`{"power": "on", "input": "hdmi!2", "volume": 30, "muted": true}`)
+	expect(t, "put state", code, http.StatusOK)
+
+	power := expectOutcome(t, outcomes, "power", device.OutcomeChanged)
+	if power.From != "standby" || power.To != "on" {
+		t.Fatalf("power went from %v to %v, want standby to on", power.From, power.To)
+	}
+
+	for _, field := range []string{"input", "volume", "muted"} {
+		if outcome := expectOutcome(t, outcomes, field, device.OutcomeChanged); outcome.Verified == nil || !*outcome.Verified {
+			t.Errorf("%s wasn't verified", field)
+		}
+	}
+
+	got := s.tv.State()
+	if !got.Power || got.Input != "extInput:hdmi?port=2" || got.Volume != 30 || !got.Muted {
+		t.Fatalf("tv is %+v after put state", got)
+	}
+}
+
+func TestReconcileSkipsInStandby(t *testing.T) {
+	s := newTestService(t, nil)
+
+	state := s.tv.State()
+	state.Power = false
+	s.tv.SetState(state)
+
+	code, outcomes, _ := s.putState(`{"input": "hdmi!2", "volume": 30, "blanked": true}`)
+	expect(t, "put state", code, http.StatusOK)
+
+	for _, field := range []string{"input", "volume", "blanked"} {
+		if outcome := expectOutcome(t, outcomes, field, device.OutcomeSkipped); outcome.Reason == "" {
+			t.Errorf("%s was skipped without a reason", field)
+		}
+	}
+
+	if _, ok := outcomes["power"]; ok {
+		t.Errorf("got a power outcome, even though power wasn't asked for")
+	}
+
+	if got := s.tv.State(); got != state {
+		t.Fatalf("tv changed from %+v to %+v while it was in standby", state, got)
+	}
+}
+
+func TestReconcileUnchanged(t *testing.T) {
+	s := newTestService(t, nil)
+
+	code, outcomes, _ := s.putState(`{"power": "on", "input": "hdmi!1", "volume": 20, "muted": true}`)
+	expect(t, "put state", code, http.StatusOK)
+
+	for _, field := range []string{"power", "input", "volume"} {
+		if outcome := expectOutcome(t, outcomes, field, device.OutcomeUnchanged); outcome.Verified != nil {
+			t.Errorf("unchanged %s has verified set", field)
+		}
+	}
+
+	muted := expectOutcome(t, outcomes, "muted", device.OutcomeChanged)
+	if muted.From != false || muted.To != true {
+		t.Fatalf("muted went from %v to %v, want false to true", muted.From, muted.To)
+	}
+
+	// doing it again changes nothing
+	code, outcomes, _ = s.putState(`{"power": "on", "volume": 20, "muted": true}`)
+	expect(t, "put state again", code, http.StatusOK)
+
+	for _, field := range []string{"power", "volume", "muted"} {
+		expectOutcome(t, outcomes, field, device.OutcomeUnchanged)
+	}
+}
+
+func TestReconcilePartialFailure(t *testing.T) {
+	s := newTestService(t, nil)
+
+	// the tv doesn't have a ninth hdmi input, so it rejects the input with an illegal argument error
+	code, outcomes, resp := s.putState(`{"input": "hdmi!9", "volume": 30}`)
+	expect(t, "put state", code, http.StatusBadRequest)
+
+	if resp == nil || resp.Code != device.ErrCodeIllegalArgument {
+		t.Fatalf("got error %+v, want %s", resp, device.ErrCodeIllegalArgument)
+	}
+
+	input := expectOutcome(t, outcomes, "input", device.OutcomeFailed)
+	if input.Error == nil || input.Error.Code != device.ErrCodeIllegalArgument {
+		t.Fatalf("input failed with %+v, want %s", input.Error, device.ErrCodeIllegalArgument)
+	}
+
+	// the fields after the one that failed are still applied
+	expectOutcome(t, outcomes, "volume", device.OutcomeChanged)
+	if got := s.tv.State(); got.Volume != 30 || got.Input != "extInput:hdmi?port=1" {
+		t.Fatalf("tv is %+v after a partial failure, want volume 30 on hdmi 1", got)
+	}
+}
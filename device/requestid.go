@@ -0,0 +1,33 @@
+package device
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader carries the id of a request, both from the client and back to it
+const RequestIDHeader = "X-Request-ID"
+
+const requestIDKey = "requestID"
+
+// RequestID is middleware that tags every request with an id, so that errors returned to the client
+// can be matched to our logs. The client's X-Request-ID is used if it sent one
+func RequestID(context *gin.Context) {
+	id := context.GetHeader(RequestIDHeader)
+	if id == "" {
+		buf := make([]byte, 8)
+		rand.Read(buf)
+		id = hex.EncodeToString(buf)
+	}
+
+	context.Set(requestIDKey, id)
+	context.Header(RequestIDHeader, id)
+	context.Next()
+}
+
+// requestID returns the id RequestID gave the request, if any
+func requestID(context *gin.Context) string {
+	return context.GetString(requestIDKey)
+}
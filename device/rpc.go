@@ -5,7 +5,6 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/byuoitav/common/status"
 	"github.com/byuoitav/sony-control-microservice/device/helpers"
@@ -16,10 +15,15 @@ import (
 func (d *DeviceManager) PowerOn(context *gin.Context) {
 	d.Log.Debug(fmt.Sprintf("Powering on %s...", context.Param("address")), zap.String("address", context.Param("address")))
 
-	err := helpers.SetPower(context, context.Param("address"), true, d)
+	if isAsync(context) {
+		d.respondPowerJob(context, true)
+		return
+	}
+
+	err := d.changePower(context.Request.Context(), context.Param("address"), true, nil)
+
 	if err != nil {
-		d.Log.Error("could not get power", zap.Error(err))
-		context.JSON(http.StatusInternalServerError, err.Error())
+		d.respondError(context, "could not power on", err)
 		return
 	}
 
@@ -30,10 +34,15 @@ func (d *DeviceManager) PowerOn(context *gin.Context) {
 func (d *DeviceManager) Standby(context *gin.Context) {
 	d.Log.Debug(fmt.Sprintf("Powering off %s...", context.Param("address")), zap.String("address", context.Param("address")))
 
-	err := helpers.SetPower(context, context.Param("address"), false, d)
+	if isAsync(context) {
+		d.respondPowerJob(context, false)
+		return
+	}
+
+	err := d.changePower(context.Request.Context(), context.Param("address"), false, nil)
+
 	if err != nil {
-		d.Log.Error("could not power off", zap.Error(err))
-		context.JSON(http.StatusInternalServerError, err.Error())
+		d.respondError(context, "could not power off", err)
 		return
 	}
 
@@ -41,13 +50,24 @@ func (d *DeviceManager) Standby(context *gin.Context) {
 	context.JSON(http.StatusOK, status.Power{Power: "standby"})
 }
 
+// respondPowerJob starts changing the device's power in the background, and responds with the job
+func (d *DeviceManager) respondPowerJob(context *gin.Context, on bool) {
+	job, err := d.startPowerJob(context, context.Param("address"), on)
+	if err != nil {
+		d.respondError(context, "could not start power job", err)
+		return
+	}
+
+	context.Header("Location", "/jobs/"+job.ID)
+	context.JSON(http.StatusAccepted, job)
+}
+
 func (d *DeviceManager) GetPower(context *gin.Context) {
 	d.Log.Debug(fmt.Sprintf("Getting power status of %s...", context.Param("address")), zap.String("address", context.Param("address")))
 
-	response, err := helpers.GetPower(context, context.Param("address"))
+	response, err := d.readPower(context.Request.Context(), context.Param("address"), isFresh(context))
 	if err != nil {
-		d.Log.Error("Failed to get Power Status", zap.Error(err))
-		context.JSON(http.StatusInternalServerError, []byte(err.Error()))
+		d.respondError(context, "Failed to get Power Status", err)
 		return
 	}
 
@@ -59,25 +79,22 @@ func (d *DeviceManager) SwitchInput(context *gin.Context) {
 	d.Log.Debug(fmt.Sprintf("Switching input for %s to %s ...", context.Param("address"), context.Param("port")),
 		zap.String("port", context.Param("port")))
 	address := context.Param("address")
-	port := context.Param("port")
-
-	splitPort := strings.Split(port, "!")
+	port := inputPort(address, context.Param("port"))
 
-	params := make(map[string]interface{})
-	if len(splitPort) < 2 {
-		context.JSON(http.StatusBadRequest, fmt.Sprintf("ports configured incorrectly (should follow format \"hdmi!2\"): %s", port))
+	if !strings.Contains(port, "!") {
+		d.respondError(context, "Failed to switch input", invalidRequest("ports configured incorrectly (should be an alias or follow format \"hdmi!2\"): %s", port))
 		return
 	}
-	params["uri"] = fmt.Sprintf("extInput:%s?port=%s", splitPort[0], splitPort[1])
 
-	err := helpers.BuildAndSendPayload(address, "avContent", "setPlayContent", params)
-	if err != nil {
-		context.JSON(http.StatusInternalServerError, err.Error())
+	verified, err := d.changeInput(context.Request.Context(), address, port)
+
+	if err = allowUnverified(err); err != nil {
+		d.respondError(context, "Failed to switch input", err)
 		return
 	}
 
 	d.Log.Info("Done.")
-	context.JSON(http.StatusOK, status.Input{Input: port})
+	context.JSON(http.StatusOK, VerifiedInput{Input: newInput(address, port), Verified: verified})
 }
 
 func (d *DeviceManager) SetVolume(context *gin.Context) {
@@ -86,174 +103,150 @@ func (d *DeviceManager) SetVolume(context *gin.Context) {
 
 	volume, err := strconv.Atoi(value)
 	if err != nil {
-		context.JSON(http.StatusBadRequest, err.Error())
+		d.respondError(context, "Failed to set volume", invalidRequest("volume must be a number, not %q", value))
 		return
 	} else if volume > 100 || volume < 0 {
-		context.JSON(http.StatusBadRequest, "Error: volume must be a value from 0 to 100!")
+		d.respondError(context, "Failed to set volume", invalidRequest("volume must be a value from 0 to 100, not %d", volume))
 		return
 	}
 
 	d.Log.Debug(fmt.Sprintf("Setting volume for %s to %v...", context.Param("address"), context.Param("value")),
 		zap.String("value", context.Param("value")), zap.String("address", context.Param("address")))
 
-	params := make(map[string]interface{})
-	params["target"] = "speaker"
-	params["volume"] = value
-
-	err = helpers.BuildAndSendPayload(address, "audio", "setAudioVolume", params)
-	if err != nil {
-		d.Log.Error("Failed to set speaker volume", zap.Error(err))
-		context.JSON(http.StatusInternalServerError, err.Error())
-		return
-	}
-
-	//do the same for the headphone
-	params = make(map[string]interface{})
-	params["target"] = "headphone"
-	params["volume"] = value
+	verified, err := d.changeVolume(context.Request.Context(), address, volume)
 
-	err = helpers.BuildAndSendPayload(address, "audio", "setAudioVolume", params)
-	if err != nil {
-		d.Log.Error("Failed to set headphone volume", zap.Error(err))
-		context.JSON(http.StatusInternalServerError, err.Error())
+	if err = allowUnverified(err); err != nil {
+		d.respondError(context, "Failed to set volume", err)
 		return
 	}
 
 	d.Log.Info("Done.")
-	context.JSON(http.StatusOK, status.Volume{Volume: volume})
+	context.JSON(http.StatusOK, VerifiedVolume{Volume: status.Volume{Volume: volume}, Verified: verified})
 }
 
 func (d *DeviceManager) VolumeUnmute(context *gin.Context) {
 	address := context.Param("address")
 	d.Log.Debug(fmt.Sprintf("Unmuting %s...", address), zap.String("address", address))
 
-	err := d.setMute(context, address, false, 4)
-	if err != nil {
-		d.Log.Error(fmt.Sprintf("Failed to set Mute: %v", err.Error()), zap.Error(err))
-		context.JSON(http.StatusInternalServerError, err.Error())
+	verified, err := d.changeMute(context.Request.Context(), address, false)
+
+	if err = allowUnverified(err); err != nil {
+		d.respondError(context, "Failed to set mute", err)
 		return
 	}
 
 	d.Log.Debug("Done.")
-	context.JSON(http.StatusOK, status.Mute{Muted: false})
+	context.JSON(http.StatusOK, VerifiedMute{Mute: status.Mute{Muted: false}, Verified: verified})
 }
 
 func (d *DeviceManager) VolumeMute(context *gin.Context) {
 	d.Log.Debug(fmt.Sprintf("Muting %s...", context.Param("address")), zap.String("address", context.Param("address")))
 
-	err := d.setMute(context, context.Param("address"), true, 4)
-	if err != nil {
-		d.Log.Error(fmt.Sprintf("Failed to set Mute: %v", err.Error()), zap.Error(err))
-		context.JSON(http.StatusInternalServerError, err.Error())
+	verified, err := d.changeMute(context.Request.Context(), context.Param("address"), true)
+
+	if err = allowUnverified(err); err != nil {
+		d.respondError(context, "Failed to set mute", err)
 		return
 	}
 
 	d.Log.Debug("Done.")
-	context.JSON(http.StatusOK, status.Mute{Muted: true})
+	context.JSON(http.StatusOK, VerifiedMute{Mute: status.Mute{Muted: true}, Verified: verified})
 }
 
-func (d *DeviceManager) setMute(context *gin.Context, address string, status bool, retryCount int) error {
-	params := make(map[string]interface{})
-	params["status"] = status
-
-	initCount := retryCount
-
-	for retryCount >= 0 {
-		err := helpers.BuildAndSendPayload(address, "audio", "setAudioMute", params)
-		if err != nil {
-			d.Log.Error("Failed to set mute again", zap.Error(err))
-			return err
-		}
-		//we need to validate that it was actually muted
-		postStatus, err := helpers.GetMute(address, d)
-		if err != nil {
-			d.Log.Error("Failed to get mute status", zap.Error(err))
-			return err
-		}
-
-		if postStatus.Muted == status {
-			return nil
-		}
-		retryCount--
-
-		//wait for a short time
-		time.Sleep(10 * time.Millisecond)
+func (d *DeviceManager) BlankDisplay(context *gin.Context) {
+	verified, err := d.changeBlanked(context.Request.Context(), context.Param("address"), true)
+
+	if err = allowUnverified(err); err != nil {
+		d.respondError(context, "Failed to blank display", err)
+		return
 	}
 
-	return fmt.Errorf("qttempted to set mute status %v times, could not", initCount+1)
+	context.JSON(http.StatusOK, VerifiedBlanked{Blanked: status.Blanked{Blanked: true}, Verified: verified})
 }
 
-func (d *DeviceManager) BlankDisplay(context *gin.Context) {
-	params := make(map[string]interface{})
-	params["mode"] = "pictureOff"
+func (d *DeviceManager) UnblankDisplay(context *gin.Context) {
+	verified, err := d.changeBlanked(context.Request.Context(), context.Param("address"), false)
 
-	err := helpers.BuildAndSendPayload(context.Param("address"), "system", "setPowerSavingMode", params)
-	if err != nil {
-		d.Log.Error("Failed to blank display", zap.Error(err))
-		context.JSON(http.StatusInternalServerError, err.Error())
+	if err = allowUnverified(err); err != nil {
+		d.respondError(context, "Failed to unblank display", err)
 		return
 	}
 
-	context.JSON(http.StatusOK, status.Blanked{Blanked: true})
+	context.JSON(http.StatusOK, VerifiedBlanked{Blanked: status.Blanked{Blanked: false}, Verified: verified})
 }
 
-func (d *DeviceManager) UnblankDisplay(context *gin.Context) {
-	params := make(map[string]interface{})
-	params["mode"] = "off"
+// SendRemoteKey presses a button on the TV's remote, e.g. Home or Confirm
+func (d *DeviceManager) SendRemoteKey(context *gin.Context) {
+	d.Log.Debug(fmt.Sprintf("Sending remote key %s to %s...", context.Param("key"), context.Param("address")),
+		zap.String("key", context.Param("key")), zap.String("address", context.Param("address")))
+
+	err := d.pressRemoteKey(context.Request.Context(), context.Param("address"), context.Param("key"))
 
-	err := helpers.BuildAndSendPayload(context.Param("address"), "system", "setPowerSavingMode", params)
 	if err != nil {
-		d.Log.Error("Failed to unblank display", zap.Error(err))
-		context.JSON(http.StatusInternalServerError, err.Error())
+		d.respondError(context, "Failed to send remote key", err)
 		return
 	}
 
-	context.JSON(http.StatusOK, status.Blanked{Blanked: false})
+	context.JSON(http.StatusOK, gin.H{"key": context.Param("key")})
 }
 
-func (d *DeviceManager) GetVolume(context *gin.Context) {
-	response, err := helpers.GetVolume(context.Param("address"), d)
+// GetRemoteKeys lists the remote keys the TV accepts
+func (d *DeviceManager) GetRemoteKeys(context *gin.Context) {
+	response, err := helpers.GetRemoteCodes(context.Request.Context(), context.Param("address"), d)
 	if err != nil {
-		d.Log.Error("Failed to get volume", zap.Error(err))
-		context.JSON(http.StatusInternalServerError, err.Error())
+		d.respondError(context, "Failed to get remote keys", err)
 		return
 	}
 
 	context.JSON(http.StatusOK, response)
 }
 
+func (d *DeviceManager) GetVolume(context *gin.Context) {
+	response, err := d.readAudio(context.Request.Context(), context.Param("address"), isFresh(context))
+	if err != nil {
+		d.respondError(context, "Failed to get volume", err)
+		return
+	}
+
+	context.JSON(http.StatusOK, response.Volume)
+}
+
 // GetInput gets the input that is currently being shown on the TV
 func (d *DeviceManager) GetInput(context *gin.Context) {
-	response, err := helpers.GetInput(context.Param("address"), d)
+	response, err := d.readAliasedInput(context, context.Param("address"))
 	if err != nil {
-		d.Log.Error("Failed to get input", zap.Error(err))
-		context.JSON(http.StatusInternalServerError, err.Error())
+		d.respondError(context, "Failed to get input", err)
 		return
 	}
 
 	context.JSON(http.StatusOK, response)
 }
 
+// GetInputList returns every external input the TV reports
 func (d *DeviceManager) GetInputList(context *gin.Context) {
-	return
+	response, err := d.getInputList(context.Request.Context(), context.Param("address"))
+	if err != nil {
+		d.respondError(context, "Failed to get input list", err)
+		return
+	}
+
+	context.JSON(http.StatusOK, response)
 }
 
 func (d *DeviceManager) GetMute(context *gin.Context) {
-	response, err := helpers.GetMute(context.Param("address"), d)
+	response, err := d.readAudio(context.Request.Context(), context.Param("address"), isFresh(context))
 	if err != nil {
-		d.Log.Error("Failed to get mute status", zap.Error(err))
-		context.JSON(http.StatusInternalServerError, err.Error())
+		d.respondError(context, "Failed to get mute status", err)
 		return
 	}
 
-	context.JSON(http.StatusOK, response)
+	context.JSON(http.StatusOK, response.Mute)
 }
 
 func (d *DeviceManager) GetBlank(context *gin.Context) {
-	response, err := helpers.GetBlanked(context.Param("address"), d)
+	response, err := d.readBlanked(context.Request.Context(), context.Param("address"), isFresh(context))
 	if err != nil {
-		d.Log.Error("Failed to get blank status", zap.Error(err))
-		context.JSON(http.StatusInternalServerError, err.Error())
+		d.respondError(context, "Failed to get blank status", err)
 		return
 	}
 
@@ -261,10 +254,9 @@ func (d *DeviceManager) GetBlank(context *gin.Context) {
 }
 
 func (d *DeviceManager) GetHardwareInfo(context *gin.Context) {
-	response, err := helpers.GetHardwareInfo(context.Param("address"), d)
+	response, err := helpers.GetHardwareInfo(context.Request.Context(), context.Param("address"), d)
 	if err != nil {
-		d.Log.Error("Failed to get hardware info", zap.Error(err))
-		context.JSON(http.StatusInternalServerError, err.Error())
+		d.respondError(context, "Failed to get hardware info", err)
 		return
 	}
 
@@ -273,10 +265,9 @@ func (d *DeviceManager) GetHardwareInfo(context *gin.Context) {
 
 // GetActiveSignal determines if the current input on the TV is active or not
 func (d *DeviceManager) GetActiveSignal(context *gin.Context) {
-	response, err := helpers.GetActiveSignal(context.Param("address"), context.Param("port"), d)
+	response, err := d.getActiveSignal(context.Request.Context(), context.Param("address"), context.Param("port"))
 	if err != nil {
-		d.Log.Error("Failed to get active signal", zap.Error(err))
-		context.JSON(http.StatusInternalServerError, err.Error())
+		d.respondError(context, "Failed to get active signal", err)
 		return
 	}
 
@@ -0,0 +1,29 @@
+package scalar
+
+// VolumeInformation is a single target in the result of getVolumeInformation
+type VolumeInformation struct {
+	Target    string `json:"target"`
+	Volume    int    `json:"volume"`
+	Mute      bool   `json:"mute"`
+	MaxVolume int    `json:"maxVolume"`
+	MinVolume int    `json:"minVolume"`
+}
+
+// SetAudioVolumeParams are the params for setAudioVolume. Volume is a string so that
+// relative changes like "+1" can be sent
+type SetAudioVolumeParams struct {
+	Target string `json:"target"`
+	Volume string `json:"volume"`
+}
+
+// SetAudioMuteParams are the params for setAudioMute
+type SetAudioMuteParams struct {
+	Status bool `json:"status"`
+}
+
+// audio service methods
+var (
+	GetVolumeInformation = Method[None, []VolumeInformation]{Service: "audio", Name: "getVolumeInformation", Version: "1.0"}
+	SetAudioVolume       = Method[SetAudioVolumeParams, None]{Service: "audio", Name: "setAudioVolume", Version: "1.0"}
+	SetAudioMute         = Method[SetAudioMuteParams, None]{Service: "audio", Name: "setAudioMute", Version: "1.0"}
+)
@@ -0,0 +1,30 @@
+package scalar
+
+// PlayingContentInfo is the result of getPlayingContentInfo
+type PlayingContentInfo struct {
+	URI    string `json:"uri"`
+	Source string `json:"source"`
+	Title  string `json:"title"`
+}
+
+// ExternalInputStatus is a single input in the result of getCurrentExternalInputsStatus
+type ExternalInputStatus struct {
+	URI        string `json:"uri"`
+	Title      string `json:"title"`
+	Label      string `json:"label"`
+	Icon       string `json:"icon"`
+	Status     string `json:"status"`
+	Connection bool   `json:"connection"`
+}
+
+// SetPlayContentParams are the params for setPlayContent
+type SetPlayContentParams struct {
+	URI string `json:"uri"`
+}
+
+// avContent service methods
+var (
+	GetPlayingContentInfo          = Method[None, PlayingContentInfo]{Service: "avContent", Name: "getPlayingContentInfo", Version: "1.0"}
+	SetPlayContent                 = Method[SetPlayContentParams, None]{Service: "avContent", Name: "setPlayContent", Version: "1.0"}
+	GetCurrentExternalInputsStatus = Method[None, []ExternalInputStatus]{Service: "avContent", Name: "getCurrentExternalInputsStatus", Version: "1.1", Fallbacks: []string{"1.0"}}
+)
@@ -0,0 +1,239 @@
+// Package scalar is a client for the Sony Scalar Web API, the JSON-RPC API Bravia TVs
+// serve at http://<address>/sony/<service>.
+package scalar
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrNoResult is returned when the TV's response doesn't include a result
+var ErrNoResult = errors.New("no result in response from tv")
+
+// Client sends requests to Sony TVs. The zero value is ready to use
+type Client struct {
+	// HTTP is used to send requests. If it's nil, a client that connects with Dial is used
+	HTTP *http.Client
+
+	// Dial connects to TVs, for both requests and notification websockets. net.Dialer is used if it's nil
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+
+	// PSK returns the pre-shared key for the TV at address. ctx is the request's
+	PSK func(ctx context.Context, address string) string
+
+	id atomic.Int64
+
+	httpOnce sync.Once
+	http     *http.Client
+}
+
+// Request is a JSON-RPC request
+type Request struct {
+	Method  string        `json:"method"`
+	Version string        `json:"version"`
+	ID      int64         `json:"id"`
+	Params  []interface{} `json:"params"`
+}
+
+// Response is a JSON-RPC response. Result is left raw so that it can be decoded
+// into whatever shape the method returns
+type Response struct {
+	ID     int64             `json:"id"`
+	Result []json.RawMessage `json:"result"`
+	Error  []interface{}     `json:"error"`
+}
+
+// None is used as the params or result type of methods that don't have any
+type None struct{}
+
+// Method describes a single Scalar API method, where P is the type of its params and R
+// is the type of the first element of its result
+type Method[P, R any] struct {
+	Service string
+	Name    string
+	Version string
+
+	// Fallbacks are older versions to try, in order, if the TV doesn't support Version
+	Fallbacks []string
+}
+
+// WithVersion returns a copy of m that calls version instead of m.Version
+func (m Method[P, R]) WithVersion(version string) Method[P, R] {
+	m.Version = version
+	m.Fallbacks = nil
+	return m
+}
+
+// Call calls the method on the TV at address and decodes the first element of its result
+func (m Method[P, R]) Call(ctx context.Context, c *Client, address string, params ...P) (R, error) {
+	var result R
+
+	raw, err := m.CallRaw(ctx, c, address, params...)
+	if err != nil {
+		return result, err
+	}
+
+	if len(raw) == 0 {
+		if _, ok := any(result).(None); ok {
+			return result, nil
+		}
+
+		return result, fmt.Errorf("%s.%s: %w", m.Service, m.Name, ErrNoResult)
+	}
+
+	if err := json.Unmarshal(raw[0], &result); err != nil {
+		return result, fmt.Errorf("unable to decode %s.%s result: %w", m.Service, m.Name, err)
+	}
+
+	return result, nil
+}
+
+// CallRaw calls the method on the TV at address and returns every element of its result
+func (m Method[P, R]) CallRaw(ctx context.Context, c *Client, address string, params ...P) ([]json.RawMessage, error) {
+	ps := make([]interface{}, len(params))
+	for i := range params {
+		ps[i] = params[i]
+	}
+
+	versions := append([]string{m.Version}, m.Fallbacks...)
+
+	var err error
+	for _, version := range versions {
+		var resp Response
+		resp, err = c.Do(ctx, address, m.Service, Request{
+			Method:  m.Name,
+			Version: version,
+			Params:  ps,
+		})
+
+		var sonyErr *SonyError
+		if errors.As(err, &sonyErr) && sonyErr.Code == ErrUnsupportedVersion {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return resp.Result, nil
+	}
+
+	return nil, err
+}
+
+// httpClient returns c.HTTP, or builds a client that connects with c.Dial
+func (c *Client) httpClient() *http.Client {
+	if c.HTTP != nil {
+		return c.HTTP
+	}
+
+	if c.Dial == nil {
+		return http.DefaultClient
+	}
+
+	c.httpOnce.Do(func() {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.DialContext = c.Dial
+		transport.Proxy = nil
+
+		c.http = &http.Client{
+			Transport: transport,
+		}
+	})
+
+	return c.http
+}
+
+// dial connects to address with c.Dial
+func (c *Client) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	if c.Dial != nil {
+		return c.Dial(ctx, network, address)
+	}
+
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, address)
+}
+
+// Do sends req to service on the TV at address, assigning it the next request id
+func (c *Client) Do(ctx context.Context, address, service string, req Request) (Response, error) {
+	var resp Response
+
+	req.ID = c.id.Add(1)
+	if req.Params == nil {
+		req.Params = []interface{}{}
+	}
+
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return resp, err
+	}
+
+	body, err := c.Post(ctx, address, "/sony/"+service, "application/json", nil, reqBody)
+	if err != nil {
+		return resp, err
+	}
+
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return resp, fmt.Errorf("unable to decode response from tv: %w", err)
+	}
+
+	if sonyErr := errorFromArray(resp.Error); sonyErr != nil {
+		return resp, sonyErr
+	}
+
+	if resp.ID != req.ID {
+		return resp, fmt.Errorf("response id %d from tv doesn't match request id %d", resp.ID, req.ID)
+	}
+
+	return resp, nil
+}
+
+// Post sends body to path on the TV at address with the TV's pre-shared key attached
+func (c *Client) Post(ctx context.Context, address, path, contentType string, headers http.Header, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://%s%s", address, path), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range headers {
+		req.Header[k] = v
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	if c.PSK != nil {
+		req.Header.Set("X-Auth-PSK", c.PSK(ctx, address))
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, &UnreachableError{Address: address, Err: err}
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &UnreachableError{Address: address, Err: err}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if sonyErr := parseError(respBody); sonyErr != nil {
+			return nil, sonyErr
+		}
+
+		return nil, &SonyError{Code: resp.StatusCode, Message: string(respBody)}
+	}
+
+	if len(respBody) == 0 {
+		return nil, errors.New("response from device was blank")
+	}
+
+	return respBody, nil
+}
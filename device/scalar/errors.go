@@ -0,0 +1,76 @@
+package scalar
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Error codes returned by the Scalar API
+const (
+	ErrAny                  = 1
+	ErrTimeout              = 2
+	ErrIllegalArgument      = 3
+	ErrIllegalRequest       = 5
+	ErrIllegalState         = 7
+	ErrNoSuchMethod         = 12
+	ErrUnsupportedVersion   = 14
+	ErrUnsupportedOperation = 15
+	ErrUnauthorized         = 401
+	ErrForbidden            = 403
+	ErrDisplayOff           = 40005
+)
+
+// SonyError is an error reported by the TV, either as a JSON-RPC [code, message] error
+// or as a non-200 http response
+type SonyError struct {
+	Code    int
+	Message string
+}
+
+func (e *SonyError) Error() string {
+	return fmt.Sprintf("error %d from tv: %s", e.Code, e.Message)
+}
+
+// UnreachableError is returned when we are unable to get any response from the TV
+type UnreachableError struct {
+	Address string
+	Err     error
+}
+
+func (e *UnreachableError) Error() string {
+	return fmt.Sprintf("unable to reach %s: %s", e.Address, e.Err)
+}
+
+func (e *UnreachableError) Unwrap() error {
+	return e.Err
+}
+
+// parseError decodes the error array from a JSON-RPC response body, if there is one
+func parseError(body []byte) *SonyError {
+	var resp struct {
+		Error []interface{} `json:"error"`
+	}
+
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil
+	}
+
+	return errorFromArray(resp.Error)
+}
+
+func errorFromArray(arr []interface{}) *SonyError {
+	if len(arr) == 0 {
+		return nil
+	}
+
+	sonyErr := &SonyError{}
+	if code, ok := arr[0].(float64); ok {
+		sonyErr.Code = int(code)
+	}
+
+	if len(arr) > 1 {
+		sonyErr.Message = fmt.Sprintf("%v", arr[1])
+	}
+
+	return sonyErr
+}
@@ -0,0 +1,70 @@
+package scalar
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+)
+
+type irccEnvelope struct {
+	XMLName       xml.Name `xml:"s:Envelope"`
+	Namespace     string   `xml:"xmlns:s,attr"`
+	EncodingStyle string   `xml:"s:encodingStyle,attr"`
+	Body          irccBody `xml:"s:Body"`
+}
+
+type irccBody struct {
+	SendIRCC irccSendIRCC `xml:"u:X_SendIRCC"`
+}
+
+type irccSendIRCC struct {
+	Namespace string `xml:"xmlns:u,attr"`
+	Code      string `xml:"IRCCCode"`
+}
+
+// RemoteCodes returns the IRCC code table from the TV's getRemoteControllerInfo result
+func (c *Client) RemoteCodes(ctx context.Context, address string) ([]RemoteCode, error) {
+	// the result is [{bundled, type}, [{name, value}, ...]]
+	raw, err := GetRemoteControllerInfo.CallRaw(ctx, c, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(raw) < 2 {
+		return nil, fmt.Errorf("getRemoteControllerInfo: %w", ErrNoResult)
+	}
+
+	var codes []RemoteCode
+	if err := json.Unmarshal(raw[1], &codes); err != nil {
+		return nil, fmt.Errorf("unable to decode remote codes: %w", err)
+	}
+
+	return codes, nil
+}
+
+// SendIRCC sends a raw IRCC code to the TV's /sony/IRCC SOAP endpoint
+func (c *Client) SendIRCC(ctx context.Context, address, code string) error {
+	envelope := irccEnvelope{
+		Namespace:     "http://schemas.xmlsoap.org/soap/envelope/",
+		EncodingStyle: "http://schemas.xmlsoap.org/soap/encoding/",
+		Body: irccBody{
+			SendIRCC: irccSendIRCC{
+				Namespace: "urn:schemas-sony-com:service:IRCC:1",
+				Code:      code,
+			},
+		},
+	}
+
+	body, err := xml.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	headers := http.Header{}
+	headers.Set("SOAPACTION", `"urn:schemas-sony-com:service:IRCC:1#X_SendIRCC"`)
+
+	_, err = c.Post(ctx, address, "/sony/IRCC", "text/xml; charset=UTF-8", headers, append([]byte(xml.Header), body...))
+	return err
+}
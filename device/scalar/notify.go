@@ -0,0 +1,221 @@
+package scalar
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Notifications the TV can push over a websocket
+const (
+	NotifyPowerStatus        = "notifyPowerStatus"
+	NotifyPlayingContentInfo = "notifyPlayingContentInfo"
+	NotifyVolumeInformation  = "notifyVolumeInformation"
+)
+
+// NotificationSetting enables or disables a single notification in switchNotifications
+type NotificationSetting struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// SwitchNotificationsParams are the params for (and result of) switchNotifications
+type SwitchNotificationsParams struct {
+	Enabled  []NotificationSetting `json:"enabled"`
+	Disabled []NotificationSetting `json:"disabled"`
+}
+
+// Notification is a message the TV pushed to us
+type Notification struct {
+	Method  string            `json:"method"`
+	Version string            `json:"version"`
+	Params  []json.RawMessage `json:"params"`
+}
+
+// NotificationConn is a websocket to one of the TV's services that notifications are pushed over. It pings
+// the TV every pingInterval, and fails if nothing (not even a pong) arrives for readTimeout, so that a
+// half-open connection is noticed instead of waited on forever
+type NotificationConn struct {
+	ws   *websocket.Conn
+	conn net.Conn
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// handshakeTimeout bounds dialing the websocket and switching notifications on
+const handshakeTimeout = 10 * time.Second
+
+var (
+	// pingInterval is how often a NotificationConn pings the TV
+	pingInterval = 30 * time.Second
+
+	// readTimeout is how long a NotificationConn waits to hear from the TV before giving up on it
+	readTimeout = 75 * time.Second
+)
+
+// deadlineConn pushes its read deadline back to readTimeout from now whenever anything is read, including
+// the pongs the websocket package handles without returning them
+type deadlineConn struct {
+	net.Conn
+	timeout time.Duration
+
+	// extend is set once the handshake is done, so that the handshake's own deadline isn't pushed back
+	extend atomic.Bool
+}
+
+func (c *deadlineConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 && c.extend.Load() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	}
+
+	return n, err
+}
+
+// DialNotifications opens a websocket to service on the TV at address and enables the
+// named notifications (version 1.0) on it
+func (c *Client) DialNotifications(ctx context.Context, address, service string, names ...string) (*NotificationConn, error) {
+	config, err := websocket.NewConfig(fmt.Sprintf("ws://%s/sony/%s", address, service), fmt.Sprintf("http://%s", address))
+	if err != nil {
+		return nil, err
+	}
+
+	config.Header = http.Header{}
+	if c.PSK != nil {
+		config.Header.Set("X-Auth-PSK", c.PSK(ctx, address))
+	}
+
+	host := address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		host = net.JoinHostPort(address, "80")
+	}
+
+	conn, err := c.dial(ctx, "tcp", host)
+	if err != nil {
+		return nil, &UnreachableError{Address: address, Err: err}
+	}
+
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+
+	dc := &deadlineConn{Conn: conn, timeout: readTimeout}
+	ws, err := websocket.NewClient(config, dc)
+	if err != nil {
+		conn.Close()
+		return nil, &UnreachableError{Address: address, Err: err}
+	}
+
+	params := SwitchNotificationsParams{
+		Enabled:  []NotificationSetting{},
+		Disabled: []NotificationSetting{},
+	}
+
+	for _, name := range names {
+		params.Enabled = append(params.Enabled, NotificationSetting{Name: name, Version: "1.0"})
+	}
+
+	req := Request{
+		Method:  "switchNotifications",
+		Version: "1.0",
+		ID:      c.id.Add(1),
+		Params:  []interface{}{params},
+	}
+
+	if err := websocket.JSON.Send(ws, req); err != nil {
+		ws.Close()
+		return nil, &UnreachableError{Address: address, Err: err}
+	}
+
+	// notifications may already be arriving, so skip anything that isn't our response
+	for {
+		var resp struct {
+			Response
+			Method string `json:"method"`
+		}
+
+		if err := websocket.JSON.Receive(ws, &resp); err != nil {
+			ws.Close()
+			return nil, &UnreachableError{Address: address, Err: err}
+		}
+
+		if resp.Method != "" || resp.ID != req.ID {
+			continue
+		}
+
+		if sonyErr := errorFromArray(resp.Error); sonyErr != nil {
+			ws.Close()
+			return nil, sonyErr
+		}
+
+		break
+	}
+
+	conn.SetDeadline(time.Now().Add(dc.timeout))
+	dc.extend.Store(true)
+
+	n := &NotificationConn{
+		ws:   ws,
+		conn: conn,
+		done: make(chan struct{}),
+	}
+
+	go n.keepalive(pingInterval)
+	return n, nil
+}
+
+// keepalive pings the TV until the connection is closed. Its pongs keep the read deadline from passing
+func (n *NotificationConn) keepalive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+		}
+
+		// nothing else writes to the websocket once notifications are switched on
+		n.conn.SetWriteDeadline(time.Now().Add(handshakeTimeout))
+		n.ws.PayloadType = websocket.PingFrame
+
+		if _, err := n.ws.Write(nil); err != nil {
+			// a failed write doesn't stop a blocked read, so close the connection to end it
+			n.Close()
+			return
+		}
+	}
+}
+
+// Next blocks until the TV pushes a notification. It fails if the TV hasn't sent anything for readTimeout
+func (n *NotificationConn) Next() (Notification, error) {
+	for {
+		var notification Notification
+		if err := websocket.JSON.Receive(n.ws, &notification); err != nil {
+			return notification, err
+		}
+
+		// ignore responses to anything but notifications
+		if notification.Method != "" {
+			return notification, nil
+		}
+	}
+}
+
+// Close closes the websocket. It's safe to call more than once
+func (n *NotificationConn) Close() error {
+	var err error
+	n.closeOnce.Do(func() {
+		close(n.done)
+		err = n.ws.Close()
+	})
+
+	return err
+}
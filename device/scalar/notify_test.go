@@ -0,0 +1,111 @@
+package scalar
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// newNotificationServer serves a websocket that switches notifications on, then calls serve
+func newNotificationServer(t *testing.T, serve func(ws *websocket.Conn)) string {
+	t.Helper()
+
+	server := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		var req Request
+		if err := websocket.JSON.Receive(ws, &req); err != nil {
+			return
+		}
+
+		if err := websocket.JSON.Send(ws, map[string]interface{}{"result": []interface{}{}, "id": req.ID}); err != nil {
+			return
+		}
+
+		serve(ws)
+	}))
+	t.Cleanup(server.Close)
+
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+// useKeepalive shortens how often notification connections are pinged, and how long they wait for the TV
+func useKeepalive(t *testing.T, ping, read time.Duration) {
+	pingBefore, readBefore := pingInterval, readTimeout
+	pingInterval, readTimeout = ping, read
+
+	t.Cleanup(func() {
+		pingInterval, readTimeout = pingBefore, readBefore
+	})
+}
+
+func TestNotificationConnHalfOpen(t *testing.T) {
+	useKeepalive(t, 20*time.Millisecond, 200*time.Millisecond)
+
+	// a tv whose connection has gone half-open never answers, not even our pings
+	gone := make(chan struct{})
+	address := newNotificationServer(t, func(ws *websocket.Conn) {
+		<-gone
+	})
+	defer close(gone)
+
+	c := &Client{}
+	conn, err := c.DialNotifications(context.Background(), address, "system", NotifyPowerStatus)
+	if err != nil {
+		t.Fatalf("unable to dial notifications: %s", err)
+	}
+	defer conn.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := conn.Next()
+		errs <- err
+	}()
+
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("got a notification from a tv that didn't send one")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("still waiting for a notification from a silent tv after 5s")
+	}
+}
+
+func TestNotificationConnKeepalive(t *testing.T) {
+	useKeepalive(t, 20*time.Millisecond, 200*time.Millisecond)
+
+	// a quiet tv still answers pings, so the connection stays up until it has something to say
+	address := newNotificationServer(t, func(ws *websocket.Conn) {
+		go func() {
+			var v interface{}
+			for websocket.JSON.Receive(ws, &v) == nil {
+			}
+		}()
+
+		time.Sleep(600 * time.Millisecond)
+		websocket.JSON.Send(ws, map[string]interface{}{
+			"method":  NotifyPowerStatus,
+			"version": "1.0",
+			"params":  []interface{}{map[string]string{"status": "active"}},
+		})
+	})
+
+	c := &Client{}
+	conn, err := c.DialNotifications(context.Background(), address, "system", NotifyPowerStatus)
+	if err != nil {
+		t.Fatalf("unable to dial notifications: %s", err)
+	}
+	defer conn.Close()
+
+	notification, err := conn.Next()
+	if err != nil {
+		t.Fatalf("connection to a quiet tv failed: %s", err)
+	}
+
+	if notification.Method != NotifyPowerStatus {
+		t.Fatalf("got notification %s, want %s", notification.Method, NotifyPowerStatus)
+	}
+}
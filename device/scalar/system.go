@@ -0,0 +1,70 @@
+package scalar
+
+// PowerStatus is the result of getPowerStatus
+type PowerStatus struct {
+	Status string `json:"status"`
+}
+
+// SetPowerStatusParams are the params for setPowerStatus
+type SetPowerStatusParams struct {
+	Status bool `json:"status"`
+}
+
+// SystemInformation is the result of getSystemInformation
+type SystemInformation struct {
+	Product    string `json:"product"`
+	Region     string `json:"region,omitempty"`
+	Language   string `json:"language,omitempty"`
+	Model      string `json:"model"`
+	Serial     string `json:"serial,omitempty"`
+	MAC        string `json:"macAddr,omitempty"`
+	Name       string `json:"name"`
+	Generation string `json:"generation,omitempty"`
+	Area       string `json:"area,omitempty"`
+	CID        string `json:"cid,omitempty"`
+}
+
+// NetworkSettingsParams are the params for getNetworkSettings
+type NetworkSettingsParams struct {
+	NetworkInterface string `json:"netif"`
+}
+
+// NetworkSettings is a single interface in the result of getNetworkSettings
+type NetworkSettings struct {
+	NetworkInterface string   `json:"netif"`
+	HardwareAddress  string   `json:"hwAddr"`
+	IPv4             string   `json:"ipAddrV4"`
+	IPv6             string   `json:"ipAddrV6"`
+	Netmask          string   `json:"netmask"`
+	Gateway          string   `json:"gateway"`
+	DNS              []string `json:"dns"`
+}
+
+// PowerSavingMode is the result of getPowerSavingMode and the params for setPowerSavingMode
+type PowerSavingMode struct {
+	Mode string `json:"mode"`
+}
+
+// RemoteControllerInfo is the first element of the result of getRemoteControllerInfo.
+// The IRCC codes are the second element, see RemoteCodes
+type RemoteControllerInfo struct {
+	Bundled bool   `json:"bundled"`
+	Type    string `json:"type"`
+}
+
+// RemoteCode is a single IRCC code from the result of getRemoteControllerInfo
+type RemoteCode struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// system service methods
+var (
+	GetPowerStatus          = Method[None, PowerStatus]{Service: "system", Name: "getPowerStatus", Version: "1.0"}
+	SetPowerStatus          = Method[SetPowerStatusParams, None]{Service: "system", Name: "setPowerStatus", Version: "1.0"}
+	GetSystemInformation    = Method[None, SystemInformation]{Service: "system", Name: "getSystemInformation", Version: "1.0"}
+	GetNetworkSettings      = Method[NetworkSettingsParams, []NetworkSettings]{Service: "system", Name: "getNetworkSettings", Version: "1.0"}
+	GetPowerSavingMode      = Method[None, PowerSavingMode]{Service: "system", Name: "getPowerSavingMode", Version: "1.0"}
+	SetPowerSavingMode      = Method[PowerSavingMode, None]{Service: "system", Name: "setPowerSavingMode", Version: "1.0"}
+	GetRemoteControllerInfo = Method[None, RemoteControllerInfo]{Service: "system", Name: "getRemoteControllerInfo", Version: "1.0"}
+)
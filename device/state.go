@@ -0,0 +1,95 @@
+package device
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/byuoitav/sony-control-microservice/device/helpers"
+	"github.com/gin-gonic/gin"
+)
+
+// Field is a single field of a device's state. Value is omitted if it couldn't be read
+type Field[T any] struct {
+	Value *T             `json:"value,omitempty"`
+	Error *ErrorResponse `json:"error,omitempty"`
+}
+
+func newField[T any](value T, err error) Field[T] {
+	if err != nil {
+		_, resp := classifyError(err)
+		return Field[T]{Error: &resp}
+	}
+
+	return Field[T]{Value: &value}
+}
+
+// State is everything we know about a device, read in one go
+type State struct {
+	Power        Field[string]           `json:"power"`
+	Input        Field[string]           `json:"input"`
+	Volume       Field[int]              `json:"volume"`
+	Muted        Field[bool]             `json:"muted"`
+	Blanked      Field[bool]             `json:"blanked"`
+	ActiveSignal Field[bool]             `json:"activeSignal"`
+	Hardware     Field[helpers.Identity] `json:"hardware"`
+}
+
+// readState reads every field of the device's state. Reads that don't depend on each other run concurrently
+func (d *DeviceManager) readState(ctx context.Context, address string, fresh bool) State {
+	var state State
+	var wg sync.WaitGroup
+
+	run := func(f func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f()
+		}()
+	}
+
+	run(func() {
+		power, err := d.readPower(ctx, address, fresh)
+		state.Power = newField(power.Power, err)
+	})
+
+	run(func() {
+		input, err := d.readInput(ctx, address, fresh)
+		state.Input = newField(input.Input, err)
+
+		// a tv in standby has no input, so it has no signal
+		if err != nil || input.Input == "" {
+			state.ActiveSignal = newField(false, err)
+			return
+		}
+
+		active, err := helpers.GetActiveSignal(ctx, address, input.Input, d)
+		state.ActiveSignal = newField(active.Active, err)
+	})
+
+	run(func() {
+		audio, err := d.readAudio(ctx, address, fresh)
+		state.Volume = newField(audio.Volume.Volume, err)
+		state.Muted = newField(audio.Mute.Muted, err)
+	})
+
+	run(func() {
+		blanked, err := d.readBlanked(ctx, address, fresh)
+		state.Blanked = newField(blanked.Blanked, err)
+	})
+
+	run(func() {
+		identity, err := d.readIdentity(ctx, address, fresh)
+		state.Hardware = newField(identity, err)
+	})
+
+	wg.Wait()
+	return state
+}
+
+// GetState returns the device's power, input, volume, mute, blanked, active signal and hardware
+// identity. Each field has its own error if it couldn't be read
+func (d *DeviceManager) GetState(context *gin.Context) {
+	state := d.readState(context.Request.Context(), context.Param("address"), isFresh(context))
+	context.JSON(http.StatusOK, state)
+}
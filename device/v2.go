@@ -0,0 +1,157 @@
+package device
+
+import (
+	"net/http"
+
+	"github.com/byuoitav/common/status"
+	"github.com/byuoitav/sony-control-microservice/device/auth"
+	"github.com/byuoitav/sony-control-microservice/device/helpers"
+	"github.com/gin-gonic/gin"
+)
+
+// handle builds a v2 handler that responds with whatever read returns
+func handle[T any](d *DeviceManager, msg string, read func(context *gin.Context, address string) (T, error)) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		data, err := read(context, context.Param("address"))
+		if err != nil {
+			d.respondError(context, msg, err)
+			return
+		}
+
+		context.JSON(http.StatusOK, Response{Data: data})
+	}
+}
+
+// bindBody reads the request body, which must set field
+func bindBody(context *gin.Context, field string) (DesiredState, error) {
+	var body DesiredState
+	if err := context.ShouldBindJSON(&body); err != nil {
+		return body, invalidRequest("%s", err)
+	}
+
+	body.resolveAliases(context.Param("address"))
+	if err := body.require(field); err != nil {
+		return body, err
+	}
+
+	return body, body.validate()
+}
+
+// handleBody builds a v2 handler that reads field from the request body, then responds with whatever write returns
+func handleBody[T any](d *DeviceManager, field, msg string, write func(context *gin.Context, address string, body DesiredState) (T, error)) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		body, err := bindBody(context, field)
+		if err != nil {
+			d.respondError(context, msg, err)
+			return
+		}
+
+		data, err := write(context, context.Param("address"), body)
+		if err != nil {
+			d.respondError(context, msg, err)
+			return
+		}
+
+		context.JSON(http.StatusOK, Response{Data: data})
+	}
+}
+
+// registerV2 registers the v2 endpoints, which read with GET and change state with PUT or POST and a JSON body.
+// Reading needs the read role, changing a device needs control, and the inventory and raw remote keys need admin
+func (d *DeviceManager) registerV2(route *gin.RouterGroup) {
+	read := route.Group("", d.authorize(auth.RoleRead))
+	control := route.Group("", d.recordAction, d.authorize(auth.RoleControl))
+	admin := route.Group("", d.authorize(auth.RoleAdmin))
+	adminAction := route.Group("", d.recordAction, d.authorize(auth.RoleAdmin))
+
+	admin.GET("/devices", d.GetDevices)
+
+	read.GET("/:address/power", handle(d, "Failed to get power status", func(context *gin.Context, address string) (status.Power, error) {
+		return d.readPower(context.Request.Context(), address, isFresh(context))
+	}))
+	setPower := handleBody(d, "power", "Failed to set power", func(context *gin.Context, address string, body DesiredState) (status.Power, error) {
+		return status.Power{Power: *body.Power}, d.changePower(context.Request.Context(), address, *body.Power == "on", nil)
+	})
+	control.PUT("/:address/power", func(context *gin.Context) {
+		if !isAsync(context) {
+			setPower(context)
+			return
+		}
+
+		// run the transition in the background, and respond with the job tracking it
+		body, err := bindBody(context, "power")
+		if err != nil {
+			d.respondError(context, "Failed to set power", err)
+			return
+		}
+
+		job, err := d.startPowerJob(context, context.Param("address"), *body.Power == "on")
+		if err != nil {
+			d.respondError(context, "Failed to start power job", err)
+			return
+		}
+
+		context.Header("Location", "/v2/jobs/"+job.ID)
+		context.JSON(http.StatusAccepted, Response{Data: job})
+	})
+
+	read.GET("/:address/input", handle(d, "Failed to get input", d.readAliasedInput))
+	control.PUT("/:address/input", handleBody(d, "input", "Failed to switch input", func(context *gin.Context, address string, body DesiredState) (VerifiedInput, error) {
+		verified, err := d.changeInput(context.Request.Context(), address, *body.Input)
+		return VerifiedInput{Input: newInput(address, *body.Input), Verified: verified}, allowUnverified(err)
+	}))
+	read.GET("/:address/inputs", handle(d, "Failed to get input list", func(context *gin.Context, address string) ([]helpers.InputInfo, error) {
+		return d.getInputList(context.Request.Context(), address)
+	}))
+	read.GET("/:address/inputs/:port/signal", handle(d, "Failed to get active signal", func(context *gin.Context, address string) (ActiveSignal, error) {
+		return d.getActiveSignal(context.Request.Context(), address, context.Param("port"))
+	}))
+
+	read.GET("/:address/volume", handle(d, "Failed to get volume", func(context *gin.Context, address string) (status.Volume, error) {
+		audio, err := d.readAudio(context.Request.Context(), address, isFresh(context))
+		return audio.Volume, err
+	}))
+	control.PUT("/:address/volume", handleBody(d, "volume", "Failed to set volume", func(context *gin.Context, address string, body DesiredState) (VerifiedVolume, error) {
+		verified, err := d.changeVolume(context.Request.Context(), address, *body.Volume)
+		return VerifiedVolume{Volume: status.Volume{Volume: *body.Volume}, Verified: verified}, allowUnverified(err)
+	}))
+
+	read.GET("/:address/mute", handle(d, "Failed to get mute status", func(context *gin.Context, address string) (status.Mute, error) {
+		audio, err := d.readAudio(context.Request.Context(), address, isFresh(context))
+		return audio.Mute, err
+	}))
+	control.PUT("/:address/mute", handleBody(d, "muted", "Failed to set mute", func(context *gin.Context, address string, body DesiredState) (VerifiedMute, error) {
+		verified, err := d.changeMute(context.Request.Context(), address, *body.Muted)
+		return VerifiedMute{Mute: status.Mute{Muted: *body.Muted}, Verified: verified}, allowUnverified(err)
+	}))
+
+	read.GET("/:address/display", handle(d, "Failed to get blank status", func(context *gin.Context, address string) (status.Blanked, error) {
+		return d.readBlanked(context.Request.Context(), address, isFresh(context))
+	}))
+	control.PUT("/:address/display", handleBody(d, "blanked", "Failed to set blank status", func(context *gin.Context, address string, body DesiredState) (VerifiedBlanked, error) {
+		verified, err := d.changeBlanked(context.Request.Context(), address, *body.Blanked)
+		return VerifiedBlanked{Blanked: status.Blanked{Blanked: *body.Blanked}, Verified: verified}, allowUnverified(err)
+	}))
+
+	read.GET("/:address/hardware", handle(d, "Failed to get hardware info", func(context *gin.Context, address string) (interface{}, error) {
+		return helpers.GetHardwareInfo(context.Request.Context(), address, d)
+	}))
+
+	read.GET("/:address/state", handle(d, "Failed to get state", func(context *gin.Context, address string) (State, error) {
+		return d.readState(context.Request.Context(), address, isFresh(context)), nil
+	}))
+	control.PUT("/:address/state", func(context *gin.Context) {
+		if r, ok := d.putState(context); ok {
+			context.JSON(http.StatusOK, Response{Data: r})
+		}
+	})
+
+	read.GET("/:address/remote", handle(d, "Failed to get remote keys", func(context *gin.Context, address string) (interface{}, error) {
+		return helpers.GetRemoteCodes(context.Request.Context(), address, d)
+	}))
+	adminAction.POST("/:address/remote/:key", handle(d, "Failed to send remote key", func(context *gin.Context, address string) (gin.H, error) {
+		return gin.H{"key": context.Param("key")}, d.pressRemoteKey(context.Request.Context(), address, context.Param("key"))
+	}))
+
+	read.GET("/:address/events", d.StreamEvents)
+}
@@ -0,0 +1,142 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/byuoitav/common/status"
+	"github.com/byuoitav/sony-control-microservice/device/helpers"
+	"go.uber.org/zap"
+)
+
+// Defaults for verifying changes
+const (
+	DefaultVerifyRetries = 4
+	DefaultVerifyBackoff = 50 * time.Millisecond
+
+	// maxVerifyBackoff caps how long we wait between retries
+	maxVerifyBackoff = 2 * time.Second
+)
+
+// VerifiedInput is the response to changing the input. Verified says whether the device reported the
+// change when it was read back, as do the other Verified types
+type VerifiedInput struct {
+	Input
+	Verified bool `json:"verified"`
+}
+
+// VerifiedVolume is the response to changing the volume
+type VerifiedVolume struct {
+	status.Volume
+	Verified bool `json:"verified"`
+}
+
+// VerifiedMute is the response to muting or unmuting
+type VerifiedMute struct {
+	status.Mute
+	Verified bool `json:"verified"`
+}
+
+// VerifiedBlanked is the response to blanking or unblanking the display
+type VerifiedBlanked struct {
+	status.Blanked
+	Verified bool `json:"verified"`
+}
+
+// ErrNotVerified is returned when the device still doesn't report a change after every retry
+var ErrNotVerified = errors.New("change didn't take effect")
+
+// verify makes a change with write, then reads the device back to check that it took. If it didn't, it
+// waits VerifyBackoff (doubling each time) and reads it again before making the change again, up to
+// VerifyRetries times. It returns ErrNotVerified if the device never reported want, and fails if it
+// can't be read back
+func verify[T comparable](ctx context.Context, d *DeviceManager, address, field string, want T, write func() error, read func() (T, error)) (bool, error) {
+	backoff := withDefault(d.VerifyBackoff, DefaultVerifyBackoff)
+	log := d.Log.With(zap.String("address", address), zap.String("field", field))
+
+	check := func() (T, bool, error) {
+		got, err := read()
+		if err != nil {
+			return got, false, fmt.Errorf("unable to verify %s: %w", field, err)
+		}
+
+		return got, got == want, nil
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err := write(); err != nil {
+			return false, err
+		}
+
+		got, ok, err := check()
+		if ok || err != nil {
+			return ok, err
+		}
+
+		// the device may just be slow to report the change, so give it a moment before making it again
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		got, ok, err = check()
+		switch {
+		case ok || err != nil:
+			return ok, err
+		case attempt > d.VerifyRetries:
+			log.Warn("Change didn't take effect", zap.Any("want", want), zap.Any("got", got), zap.Int("attempts", attempt))
+			return false, fmt.Errorf("%w: %s is %v, not %v, after %d attempts", ErrNotVerified, field, got, want, attempt)
+		}
+
+		log.Info("Change didn't take effect, retrying", zap.Any("want", want), zap.Any("got", got), zap.Duration("backoff", backoff))
+		backoff = min(backoff*2, maxVerifyBackoff)
+	}
+}
+
+// allowUnverified lets a change that didn't take effect succeed, for responses that say whether it was verified
+func allowUnverified(err error) error {
+	if errors.Is(err, ErrNotVerified) {
+		return nil
+	}
+
+	return err
+}
+
+func (d *DeviceManager) setInput(ctx context.Context, address, port string) (bool, error) {
+	return verify(ctx, d, address, "input", port, func() error {
+		return helpers.SetInput(ctx, address, port)
+	}, func() (string, error) {
+		input, err := helpers.GetCurrentInput(ctx, address, d)
+		return input.Input, err
+	})
+}
+
+func (d *DeviceManager) setVolume(ctx context.Context, address string, volume int) (bool, error) {
+	return verify(ctx, d, address, "volume", volume, func() error {
+		return helpers.SetVolume(ctx, address, volume)
+	}, func() (int, error) {
+		volume, err := helpers.GetVolume(ctx, address, d)
+		return volume.Volume, err
+	})
+}
+
+func (d *DeviceManager) setMute(ctx context.Context, address string, muted bool) (bool, error) {
+	return verify(ctx, d, address, "muted", muted, func() error {
+		return helpers.SetMute(ctx, address, muted)
+	}, func() (bool, error) {
+		mute, err := helpers.GetMute(ctx, address, d)
+		return mute.Muted, err
+	})
+}
+
+func (d *DeviceManager) setBlanked(ctx context.Context, address string, blanked bool) (bool, error) {
+	return verify(ctx, d, address, "blanked", blanked, func() error {
+		return helpers.SetBlanked(ctx, address, blanked)
+	}, func() (bool, error) {
+		status, err := helpers.GetBlanked(ctx, address, d)
+		return status.Blanked, err
+	})
+}
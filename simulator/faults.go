@@ -0,0 +1,148 @@
+package simulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// Duration is a time.Duration that is written as a string ("250ms") in JSON
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"250ms\": %w", err)
+	}
+
+	dur, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(dur)
+	return nil
+}
+
+// Faults configures the ways a simulated TV misbehaves. The zero value is a well behaved TV
+type Faults struct {
+	// Latency is added to every Scalar API and IRCC request
+	Latency Duration `json:"latency"`
+
+	// DropRate is the fraction (0-1) of requests whose connection is closed without a response
+	DropRate float64 `json:"dropRate"`
+
+	// ErrorRate is the fraction (0-1) of requests answered with an http 500
+	ErrorRate float64 `json:"errorRate"`
+
+	// DisplayOffErrors makes audio and avContent methods fail with error 40005
+	// while the TV is in standby or its picture is off
+	DisplayOffErrors bool `json:"displayOffErrors"`
+
+	// PowerOnDelay and PowerOffDelay are how long getPowerStatus keeps reporting the old
+	// status after setPowerStatus is called
+	PowerOnDelay  Duration `json:"powerOnDelay"`
+	PowerOffDelay Duration `json:"powerOffDelay"`
+
+	// StaleMuteReads is how many getVolumeInformation calls after setAudioMute
+	// still report the old mute status
+	StaleMuteReads int `json:"staleMuteReads"`
+}
+
+// powerTransition is a power change that hasn't finished yet
+type powerTransition struct {
+	power bool
+	at    time.Time
+}
+
+// staleMute is a mute change getVolumeInformation isn't reporting yet
+type staleMute struct {
+	muted bool
+	reads int
+}
+
+// Faults returns the TV's current faults
+func (tv *TV) Faults() Faults {
+	tv.mu.Lock()
+	defer tv.mu.Unlock()
+
+	return tv.faults
+}
+
+// SetFaults replaces the TV's faults
+func (tv *TV) SetFaults(faults Faults) {
+	tv.mu.Lock()
+	defer tv.mu.Unlock()
+
+	tv.faults = faults
+}
+
+// injectFaults applies latency, dropped connections and http errors to a request.
+// It returns false if the request has already been answered
+func (tv *TV) injectFaults(w http.ResponseWriter, r *http.Request) bool {
+	faults := tv.Faults()
+
+	if faults.Latency > 0 {
+		select {
+		case <-time.After(time.Duration(faults.Latency)):
+		case <-r.Context().Done():
+			return false
+		}
+	}
+
+	if faults.DropRate > 0 && rand.Float64() < faults.DropRate {
+		if hj, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hj.Hijack(); err == nil {
+				conn.Close()
+				return false
+			}
+		}
+
+		panic(http.ErrAbortHandler)
+	}
+
+	if faults.ErrorRate > 0 && rand.Float64() < faults.ErrorRate {
+		http.Error(w, "simulated internal server error", http.StatusInternalServerError)
+		return false
+	}
+
+	return true
+}
+
+// settle finishes any power transition whose delay has passed. tv.mu must be held
+func (tv *TV) settle() {
+	if tv.transition != nil && !time.Now().Before(tv.transition.at) {
+		tv.state.Power = tv.transition.power
+		tv.transition = nil
+	}
+}
+
+// displayOff returns true if the TV's display is off. tv.mu must be held
+func (tv *TV) displayOff() bool {
+	return !tv.state.Power || tv.state.PowerSavingMode == "pictureOff"
+}
+
+// serveFaults lets tests read (GET) or replace (PUT) the TV's faults
+func (tv *TV) serveFaults(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, tv.Faults())
+	case http.MethodPut:
+		var faults Faults
+		if err := json.NewDecoder(r.Body).Decode(&faults); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		tv.SetFaults(faults)
+		writeJSON(w, http.StatusOK, faults)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,62 @@
+package simulator
+
+import (
+	"encoding/xml"
+	"net/http"
+)
+
+type irccCode struct {
+	name   string
+	value  string
+	action func(state *State)
+}
+
+// irccCodes is a subset of a real Bravia's code table. Codes without an action are accepted but ignored
+var irccCodes = []irccCode{
+	{"PowerOff", "AAAAAQAAAAEAAAAvAw==", func(s *State) { s.Power = false }},
+	{"TvPower", "AAAAAQAAAAEAAAAVAw==", func(s *State) { s.Power = !s.Power }},
+	{"VolumeUp", "AAAAAQAAAAEAAAASAw==", func(s *State) { s.Volume = min(s.Volume+1, 100) }},
+	{"VolumeDown", "AAAAAQAAAAEAAAATAw==", func(s *State) { s.Volume = max(s.Volume-1, 0) }},
+	{"Mute", "AAAAAQAAAAEAAAAUAw==", func(s *State) { s.Muted = !s.Muted }},
+	{"Home", "AAAAAQAAAAEAAABgAw==", nil},
+	{"Confirm", "AAAAAQAAAAEAAABlAw==", nil},
+	{"Up", "AAAAAQAAAAEAAAB0Aw==", nil},
+	{"Down", "AAAAAQAAAAEAAAB1Aw==", nil},
+	{"Left", "AAAAAQAAAAEAAAA0Aw==", nil},
+	{"Right", "AAAAAQAAAAEAAAAzAw==", nil},
+	{"Return", "AAAAAgAAAJcAAAAjAw==", nil},
+	{"ActionMenu", "AAAAAgAAAMQAAABLAw==", nil},
+	{"PictureMode", "AAAAAQAAAAEAAABkAw==", nil},
+}
+
+func (tv *TV) serveIRCC(w http.ResponseWriter, r *http.Request) {
+	var envelope struct {
+		Code string `xml:"Body>X_SendIRCC>IRCCCode"`
+	}
+
+	if err := xml.NewDecoder(r.Body).Decode(&envelope); err != nil {
+		http.Error(w, "invalid soap request", http.StatusInternalServerError)
+		return
+	}
+
+	for _, code := range irccCodes {
+		if code.value != envelope.Code {
+			continue
+		}
+
+		if code.action != nil {
+			tv.mu.Lock()
+			before := tv.state
+			code.action(&tv.state)
+			tv.notifyChanges(before)
+			tv.mu.Unlock()
+		}
+
+		w.Header().Set("Content-Type", "text/xml; charset=UTF-8")
+		w.Write([]byte(xml.Header + `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body><u:X_SendIRCCResponse xmlns:u="urn:schemas-sony-com:service:IRCC:1"></u:X_SendIRCCResponse></s:Body></s:Envelope>`))
+		return
+	}
+
+	// real TVs answer unknown codes with a soap fault
+	http.Error(w, "invalid ircc code", http.StatusInternalServerError)
+}
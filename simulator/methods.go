@@ -0,0 +1,293 @@
+package simulator
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+type none = map[string]interface{}
+
+var services = map[string]map[string]method{
+	"system": {
+		"getPowerStatus":          {[]string{"1.0"}, getPowerStatus},
+		"setPowerStatus":          {[]string{"1.0"}, setPowerStatus},
+		"getSystemInformation":    {[]string{"1.0"}, getSystemInformation},
+		"getNetworkSettings":      {[]string{"1.0"}, getNetworkSettings},
+		"getPowerSavingMode":      {[]string{"1.0"}, getPowerSavingMode},
+		"setPowerSavingMode":      {[]string{"1.0"}, setPowerSavingMode},
+		"getRemoteControllerInfo": {[]string{"1.0"}, getRemoteControllerInfo},
+	},
+	"audio": {
+		"getVolumeInformation": {[]string{"1.0"}, getVolumeInformation},
+		"setAudioVolume":       {[]string{"1.0"}, setAudioVolume},
+		"setAudioMute":         {[]string{"1.0"}, setAudioMute},
+	},
+	"avContent": {
+		"getPlayingContentInfo":          {[]string{"1.0"}, getPlayingContentInfo},
+		"setPlayContent":                 {[]string{"1.0"}, setPlayContent},
+		"getCurrentExternalInputsStatus": {[]string{"1.0", "1.1"}, getCurrentExternalInputsStatus},
+	},
+	"appControl": {
+		"getApplicationList": {[]string{"1.0"}, getApplicationList},
+		"setActiveApp":       {[]string{"1.0"}, setActiveApp},
+	},
+}
+
+var apps = []map[string]string{
+	{"title": "YouTube", "uri": "com.sony.dtv.com.google.android.youtube.tv.com.google.android.apps.youtube.tv.activity.ShellActivity", "icon": ""},
+	{"title": "Netflix", "uri": "com.sony.dtv.com.netflix.ninja.com.netflix.ninja.MainActivity", "icon": ""},
+}
+
+func getPowerStatus(tv *TV, req rpcRequest) ([]interface{}, *rpcError) {
+	status := "standby"
+	if tv.state.Power {
+		status = "active"
+	}
+
+	return []interface{}{none{"status": status}}, nil
+}
+
+func setPowerStatus(tv *TV, req rpcRequest) ([]interface{}, *rpcError) {
+	var p struct {
+		Status *bool `json:"status"`
+	}
+
+	if err := param(req, &p); err != nil {
+		return nil, err
+	}
+
+	if p.Status == nil {
+		return nil, &rpcError{errIllegalArgument, "Illegal Argument"}
+	}
+
+	delay := tv.faults.PowerOffDelay
+	if *p.Status {
+		delay = tv.faults.PowerOnDelay
+	}
+
+	switch {
+	case *p.Status == tv.state.Power:
+		tv.transition = nil
+	case delay > 0:
+		if tv.transition == nil || tv.transition.power != *p.Status {
+			tv.transition = &powerTransition{power: *p.Status, at: time.Now().Add(time.Duration(delay))}
+			time.AfterFunc(time.Duration(delay), tv.settleAndNotify)
+		}
+	default:
+		tv.state.Power = *p.Status
+	}
+
+	return []interface{}{}, nil
+}
+
+func getSystemInformation(tv *TV, req rpcRequest) ([]interface{}, *rpcError) {
+	return []interface{}{none{
+		"product":    "TV",
+		"region":     "USA",
+		"language":   "eng",
+		"model":      tv.Model,
+		"serial":     tv.Serial,
+		"macAddr":    tv.MAC,
+		"name":       "BRAVIA",
+		"generation": "5.2.0",
+		"area":       "USA",
+		"cid":        "simulator",
+	}}, nil
+}
+
+func getNetworkSettings(tv *TV, req rpcRequest) ([]interface{}, *rpcError) {
+	return []interface{}{[]none{{
+		"netif":    "eth0",
+		"hwAddr":   tv.MAC,
+		"ipAddrV4": tv.IP,
+		"ipAddrV6": "",
+		"netmask":  "255.255.255.0",
+		"gateway":  "127.0.0.1",
+		"dns":      []string{"127.0.0.1"},
+	}}}, nil
+}
+
+func getPowerSavingMode(tv *TV, req rpcRequest) ([]interface{}, *rpcError) {
+	return []interface{}{none{"mode": tv.state.PowerSavingMode}}, nil
+}
+
+func setPowerSavingMode(tv *TV, req rpcRequest) ([]interface{}, *rpcError) {
+	var p struct {
+		Mode string `json:"mode"`
+	}
+
+	if err := param(req, &p); err != nil {
+		return nil, err
+	}
+
+	switch p.Mode {
+	case "off", "low", "high", "pictureOff":
+	default:
+		return nil, &rpcError{errIllegalArgument, "Illegal Argument"}
+	}
+
+	tv.state.PowerSavingMode = p.Mode
+	return []interface{}{}, nil
+}
+
+func getRemoteControllerInfo(tv *TV, req rpcRequest) ([]interface{}, *rpcError) {
+	codes := []none{}
+	for _, code := range irccCodes {
+		codes = append(codes, none{"name": code.name, "value": code.value})
+	}
+
+	return []interface{}{none{"bundled": true, "type": "RM-J1100"}, codes}, nil
+}
+
+func getVolumeInformation(tv *TV, req rpcRequest) ([]interface{}, *rpcError) {
+	muted := tv.state.Muted
+	if tv.staleMute != nil {
+		muted = tv.staleMute.muted
+
+		tv.staleMute.reads--
+		if tv.staleMute.reads <= 0 {
+			tv.staleMute = nil
+		}
+	}
+
+	return []interface{}{[]none{
+		{"target": "speaker", "volume": tv.state.Volume, "mute": muted, "maxVolume": 100, "minVolume": 0},
+		{"target": "headphone", "volume": tv.state.HeadphoneVolume, "mute": muted, "maxVolume": 100, "minVolume": 0},
+	}}, nil
+}
+
+func setAudioVolume(tv *TV, req rpcRequest) ([]interface{}, *rpcError) {
+	var p struct {
+		Target string `json:"target"`
+		Volume string `json:"volume"`
+	}
+
+	if err := param(req, &p); err != nil {
+		return nil, err
+	}
+
+	var volume *int
+	switch p.Target {
+	case "speaker", "":
+		volume = &tv.state.Volume
+	case "headphone":
+		volume = &tv.state.HeadphoneVolume
+	default:
+		return nil, &rpcError{errIllegalArgument, "Illegal Argument"}
+	}
+
+	// the volume is either absolute ("30") or relative ("+1", "-1")
+	value, err := strconv.Atoi(p.Volume)
+	if err != nil {
+		return nil, &rpcError{errIllegalArgument, "Illegal Argument"}
+	}
+
+	if strings.HasPrefix(p.Volume, "+") || strings.HasPrefix(p.Volume, "-") {
+		value += *volume
+	}
+
+	*volume = min(max(value, 0), 100)
+	return []interface{}{}, nil
+}
+
+func setAudioMute(tv *TV, req rpcRequest) ([]interface{}, *rpcError) {
+	var p struct {
+		Status *bool `json:"status"`
+	}
+
+	if err := param(req, &p); err != nil {
+		return nil, err
+	}
+
+	if p.Status == nil {
+		return nil, &rpcError{errIllegalArgument, "Illegal Argument"}
+	}
+
+	if tv.faults.StaleMuteReads > 0 && tv.state.Muted != *p.Status {
+		tv.staleMute = &staleMute{muted: tv.state.Muted, reads: tv.faults.StaleMuteReads}
+	}
+
+	tv.state.Muted = *p.Status
+	return []interface{}{}, nil
+}
+
+func getPlayingContentInfo(tv *TV, req rpcRequest) ([]interface{}, *rpcError) {
+	// real TVs don't report any content while they are off or showing an app
+	if !tv.state.Power || tv.state.App != "" {
+		return nil, &rpcError{errIllegalState, "Illegal State"}
+	}
+
+	for _, input := range tv.inputs {
+		if input.URI == tv.state.Input {
+			return []interface{}{none{"uri": input.URI, "source": "extInput:hdmi", "title": input.Title}}, nil
+		}
+	}
+
+	return nil, &rpcError{errIllegalState, "Illegal State"}
+}
+
+func setPlayContent(tv *TV, req rpcRequest) ([]interface{}, *rpcError) {
+	var p struct {
+		URI string `json:"uri"`
+	}
+
+	if err := param(req, &p); err != nil {
+		return nil, err
+	}
+
+	for _, input := range tv.inputs {
+		if input.URI == p.URI {
+			tv.state.Input = p.URI
+			tv.state.App = ""
+			return []interface{}{}, nil
+		}
+	}
+
+	return nil, &rpcError{errIllegalArgument, "Illegal Argument"}
+}
+
+func getCurrentExternalInputsStatus(tv *TV, req rpcRequest) ([]interface{}, *rpcError) {
+	inputs := []none{}
+	for _, input := range tv.inputs {
+		i := none{
+			"uri":        input.URI,
+			"title":      input.Title,
+			"connection": input.Signal,
+			"label":      input.Label,
+			"icon":       input.Icon,
+		}
+
+		// status was added in 1.1
+		if req.Version == "1.1" {
+			i["status"] = strconv.FormatBool(input.Signal && tv.state.Power)
+		}
+
+		inputs = append(inputs, i)
+	}
+
+	return []interface{}{inputs}, nil
+}
+
+func getApplicationList(tv *TV, req rpcRequest) ([]interface{}, *rpcError) {
+	return []interface{}{apps}, nil
+}
+
+func setActiveApp(tv *TV, req rpcRequest) ([]interface{}, *rpcError) {
+	var p struct {
+		URI string `json:"uri"`
+	}
+
+	if err := param(req, &p); err != nil {
+		return nil, err
+	}
+
+	for _, app := range apps {
+		if app["uri"] == p.URI {
+			tv.state.App = p.URI
+			return []interface{}{}, nil
+		}
+	}
+
+	return nil, &rpcError{errIllegalArgument, "Illegal Argument"}
+}
@@ -0,0 +1,202 @@
+package simulator
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"golang.org/x/net/websocket"
+)
+
+// notificationsByService are the notifications each service can push
+var notificationsByService = map[string][]string{
+	"system":    {"notifyPowerStatus"},
+	"audio":     {"notifyVolumeInformation"},
+	"avContent": {"notifyPlayingContentInfo"},
+}
+
+type notificationSetting struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// subscriber is a websocket client of one of the TV's services
+type subscriber struct {
+	service string
+	enabled map[string]bool
+	send    chan interface{}
+}
+
+// serveWebSocket answers JSON-RPC requests over a websocket and pushes the notifications
+// the client enables with switchNotifications
+func (tv *TV) serveWebSocket(w http.ResponseWriter, r *http.Request, service string) {
+	server := websocket.Server{
+		// real TVs don't check the origin
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(ws *websocket.Conn) {
+			tv.serveNotifications(ws, service)
+		},
+	}
+
+	server.ServeHTTP(w, r)
+}
+
+func (tv *TV) serveNotifications(ws *websocket.Conn, service string) {
+	sub := &subscriber{
+		service: service,
+		enabled: make(map[string]bool),
+		send:    make(chan interface{}, 32),
+	}
+
+	tv.mu.Lock()
+	if tv.subscribers == nil {
+		tv.subscribers = make(map[*subscriber]struct{})
+	}
+	tv.subscribers[sub] = struct{}{}
+	tv.mu.Unlock()
+
+	done := make(chan struct{})
+	defer func() {
+		tv.mu.Lock()
+		delete(tv.subscribers, sub)
+		tv.mu.Unlock()
+
+		close(done)
+	}()
+
+	go func() {
+		for {
+			select {
+			case msg := <-sub.send:
+				if err := websocket.JSON.Send(ws, msg); err != nil {
+					ws.Close()
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	methods := services[service]
+	for {
+		var req rpcRequest
+		if err := websocket.JSON.Receive(ws, &req); err != nil {
+			return
+		}
+
+		var result []interface{}
+		var rpcErr *rpcError
+
+		if req.Method == "switchNotifications" {
+			result, rpcErr = tv.switchNotifications(sub, req)
+		} else {
+			result, rpcErr = tv.call(service, methods, req)
+		}
+
+		resp := map[string]interface{}{"id": req.ID}
+		if rpcErr != nil {
+			resp["error"] = []interface{}{rpcErr.code, rpcErr.msg}
+		} else {
+			resp["result"] = result
+		}
+
+		select {
+		case sub.send <- resp:
+		default:
+			// the client isn't keeping up
+			ws.Close()
+			return
+		}
+	}
+}
+
+func (tv *TV) switchNotifications(sub *subscriber, req rpcRequest) ([]interface{}, *rpcError) {
+	tv.mu.Lock()
+	defer tv.mu.Unlock()
+
+	// with no params, the TV just reports what is enabled
+	if len(req.Params) > 0 {
+		var p struct {
+			Enabled  []notificationSetting `json:"enabled"`
+			Disabled []notificationSetting `json:"disabled"`
+		}
+
+		if err := json.Unmarshal(req.Params[0], &p); err != nil {
+			return nil, &rpcError{errIllegalArgument, "Illegal Argument"}
+		}
+
+		for _, n := range p.Enabled {
+			sub.enabled[n.Name] = true
+		}
+
+		for _, n := range p.Disabled {
+			delete(sub.enabled, n.Name)
+		}
+	}
+
+	enabled := []notificationSetting{}
+	disabled := []notificationSetting{}
+	for _, name := range notificationsByService[sub.service] {
+		if sub.enabled[name] {
+			enabled = append(enabled, notificationSetting{Name: name, Version: "1.0"})
+		} else {
+			disabled = append(disabled, notificationSetting{Name: name, Version: "1.0"})
+		}
+	}
+
+	return []interface{}{map[string]interface{}{"enabled": enabled, "disabled": disabled}}, nil
+}
+
+// notifyChanges pushes notifications for anything that changed since before. tv.mu must be held
+func (tv *TV) notifyChanges(before State) {
+	if before.Power != tv.state.Power {
+		status := "standby"
+		if tv.state.Power {
+			status = "active"
+		}
+
+		tv.push("notifyPowerStatus", none{"status": status})
+	}
+
+	if before.Input != tv.state.Input || before.App != tv.state.App {
+		content := none{"uri": tv.state.App, "source": "", "title": ""}
+		if tv.state.App == "" {
+			content = none{"uri": tv.state.Input, "source": "extInput:hdmi", "title": ""}
+			for _, input := range tv.inputs {
+				if input.URI == tv.state.Input {
+					content["title"] = input.Title
+				}
+			}
+		}
+
+		tv.push("notifyPlayingContentInfo", content)
+	}
+
+	if before.Volume != tv.state.Volume || before.Muted != tv.state.Muted {
+		tv.push("notifyVolumeInformation", none{"target": "speaker", "volume": tv.state.Volume, "mute": tv.state.Muted})
+	}
+}
+
+// push sends a notification to every subscriber that enabled it. tv.mu must be held
+func (tv *TV) push(name string, params interface{}) {
+	for sub := range tv.subscribers {
+		if !sub.enabled[name] {
+			continue
+		}
+
+		select {
+		case sub.send <- none{"method": name, "params": []interface{}{params}, "version": "1.0"}:
+		default:
+		}
+	}
+}
+
+// settleAndNotify finishes any power transition that is due and notifies subscribers
+func (tv *TV) settleAndNotify() {
+	tv.mu.Lock()
+	defer tv.mu.Unlock()
+
+	before := tv.state
+	tv.settle()
+	tv.notifyChanges(before)
+}
@@ -0,0 +1,315 @@
+// Package simulator is a fake Sony Bravia TV. It serves the Scalar API methods and IRCC
+// endpoint this service uses, and keeps enough state to answer them consistently.
+package simulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Scalar API error codes the simulator returns
+const (
+	errIllegalArgument    = 3
+	errIllegalRequest     = 5
+	errIllegalState       = 7
+	errNoSuchMethod       = 12
+	errUnsupportedVersion = 14
+	errForbidden          = 403
+	errDisplayOff         = 40005
+)
+
+// State is the mutable state of a simulated TV
+type State struct {
+	Power           bool   `json:"power"`
+	Input           string `json:"input"`
+	App             string `json:"app,omitempty"`
+	Volume          int    `json:"volume"`
+	HeadphoneVolume int    `json:"headphoneVolume"`
+	Muted           bool   `json:"muted"`
+	PowerSavingMode string `json:"powerSavingMode"`
+}
+
+// Input is an external input on a simulated TV
+type Input struct {
+	URI    string `json:"uri"`
+	Title  string `json:"title"`
+	Label  string `json:"label"`
+	Icon   string `json:"icon"`
+	Signal bool   `json:"signal"`
+}
+
+// TV is a simulated Sony Bravia. It implements http.Handler
+type TV struct {
+	Model  string
+	Serial string
+	MAC    string
+	IP     string
+
+	mu sync.Mutex
+
+	// psk is the pre-shared key requests must have in X-Auth-PSK. Any key is accepted if it's empty
+	psk string
+
+	state       State
+	inputs      []Input
+	faults      Faults
+	transition  *powerTransition
+	staleMute   *staleMute
+	subscribers map[*subscriber]struct{}
+
+	// calls counts the Scalar API calls to each method
+	calls map[string]int
+}
+
+type rpcRequest struct {
+	Method  string            `json:"method"`
+	Version string            `json:"version"`
+	ID      int64             `json:"id"`
+	Params  []json.RawMessage `json:"params"`
+}
+
+type rpcError struct {
+	code int
+	msg  string
+}
+
+type method struct {
+	versions []string
+	handler  func(tv *TV, req rpcRequest) ([]interface{}, *rpcError)
+}
+
+// New returns a simulated TV that is on, showing hdmi!1, with four hdmi inputs
+func New(psk string) *TV {
+	tv := &TV{
+		psk:    psk,
+		Model:  "FW-65BZ35F",
+		Serial: "4000001",
+		MAC:    "fc:f1:52:00:00:01",
+		IP:     "127.0.0.1",
+		state: State{
+			Power:           true,
+			Input:           "extInput:hdmi?port=1",
+			Volume:          20,
+			HeadphoneVolume: 20,
+			PowerSavingMode: "off",
+		},
+	}
+
+	for i := 1; i <= 4; i++ {
+		tv.inputs = append(tv.inputs, Input{
+			URI:    fmt.Sprintf("extInput:hdmi?port=%d", i),
+			Title:  fmt.Sprintf("HDMI %d", i),
+			Icon:   "meta:hdmi",
+			Signal: i == 1,
+		})
+	}
+
+	return tv
+}
+
+// State returns a copy of the TV's current state
+func (tv *TV) State() State {
+	tv.mu.Lock()
+	defer tv.mu.Unlock()
+
+	tv.settle()
+	return tv.state
+}
+
+// SetState replaces the TV's current state
+func (tv *TV) SetState(state State) {
+	tv.mu.Lock()
+	defer tv.mu.Unlock()
+
+	before := tv.state
+	tv.state = state
+	tv.transition = nil
+	tv.staleMute = nil
+	tv.notifyChanges(before)
+}
+
+// PSK returns the pre-shared key the TV accepts
+func (tv *TV) PSK() string {
+	tv.mu.Lock()
+	defer tv.mu.Unlock()
+
+	return tv.psk
+}
+
+// SetPSK changes the pre-shared key the TV accepts. Any key is accepted if it's empty
+func (tv *TV) SetPSK(psk string) {
+	tv.mu.Lock()
+	defer tv.mu.Unlock()
+
+	tv.psk = psk
+}
+
+// Inputs returns a copy of the TV's inputs
+func (tv *TV) Inputs() []Input {
+	tv.mu.Lock()
+	defer tv.mu.Unlock()
+
+	return append([]Input{}, tv.inputs...)
+}
+
+// SetInputs replaces the TV's inputs
+func (tv *TV) SetInputs(inputs []Input) {
+	tv.mu.Lock()
+	defer tv.mu.Unlock()
+
+	tv.inputs = append([]Input{}, inputs...)
+}
+
+// Calls returns how many times the Scalar API method has been called, e.g. to check what a client cached
+func (tv *TV) Calls(method string) int {
+	tv.mu.Lock()
+	defer tv.mu.Unlock()
+
+	return tv.calls[method]
+}
+
+func (tv *TV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/simulator/state":
+		tv.serveState(w, r)
+		return
+	case "/simulator/faults":
+		tv.serveFaults(w, r)
+		return
+	}
+
+	upgrade := strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+	if (r.Method != http.MethodPost && !upgrade) || !strings.HasPrefix(r.URL.Path, "/sony/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !tv.injectFaults(w, r) {
+		return
+	}
+
+	if psk := tv.PSK(); psk != "" && r.Header.Get("X-Auth-PSK") != psk {
+		writeJSON(w, http.StatusForbidden, map[string]interface{}{
+			"error": []interface{}{errForbidden, "Forbidden"},
+		})
+		return
+	}
+
+	service := strings.TrimPrefix(r.URL.Path, "/sony/")
+	if _, ok := notificationsByService[service]; ok && upgrade {
+		tv.serveWebSocket(w, r, service)
+		return
+	}
+	if service == "IRCC" {
+		tv.serveIRCC(w, r)
+		return
+	}
+
+	methods, ok := services[service]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	var req rpcRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"error": []interface{}{errIllegalRequest, "Illegal Request"},
+		})
+		return
+	}
+
+	result, rpcErr := tv.call(service, methods, req)
+	if rpcErr != nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"error": []interface{}{rpcErr.code, rpcErr.msg},
+			"id":    req.ID,
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"result": result,
+		"id":     req.ID,
+	})
+}
+
+// serveState lets tests read (GET) or replace (PUT) the TV's state
+func (tv *TV) serveState(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, tv.State())
+	case http.MethodPut:
+		var state State
+		if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		tv.SetState(state)
+		writeJSON(w, http.StatusOK, state)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (tv *TV) call(service string, methods map[string]method, req rpcRequest) ([]interface{}, *rpcError) {
+	m, ok := methods[req.Method]
+	if !ok {
+		return nil, &rpcError{errNoSuchMethod, "No Such Method"}
+	}
+
+	supported := false
+	for _, v := range m.versions {
+		if v == req.Version {
+			supported = true
+		}
+	}
+
+	if !supported {
+		return nil, &rpcError{errUnsupportedVersion, "Unsupported Version"}
+	}
+
+	tv.mu.Lock()
+	defer tv.mu.Unlock()
+
+	if tv.calls == nil {
+		tv.calls = make(map[string]int)
+	}
+	tv.calls[req.Method]++
+
+	before := tv.state
+	defer func() {
+		tv.notifyChanges(before)
+	}()
+
+	tv.settle()
+
+	if tv.faults.DisplayOffErrors && tv.displayOff() && (service == "audio" || service == "avContent") {
+		return nil, &rpcError{errDisplayOff, "Display Is Turned Off"}
+	}
+
+	return m.handler(tv, req)
+}
+
+// param decodes the first param of req into v
+func param(req rpcRequest, v interface{}) *rpcError {
+	if len(req.Params) == 0 {
+		return &rpcError{errIllegalArgument, "Illegal Argument"}
+	}
+
+	if err := json.Unmarshal(req.Params[0], v); err != nil {
+		return &rpcError{errIllegalArgument, "Illegal Argument"}
+	}
+
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}